@@ -0,0 +1,126 @@
+package isal
+
+import "io"
+
+// BackendKind names an implementation of the codecs behind Writer, Reader,
+// Compress and Decompress, see Opts.Backend.
+type BackendKind int
+
+const (
+	// BackendAuto uses ISA-L if the library can be loaded and BackendGo
+	// otherwise.
+	BackendAuto BackendKind = iota
+	// BackendISAL always uses ISA-L. Constructors fail with the error of
+	// LoadDefault if the library cannot be loaded.
+	BackendISAL
+	// BackendGo uses compress/flate, which needs neither ISA-L nor cgo. It
+	// writes and reads the same formats, headers, trailers and dictionaries
+	// as ISA-L, with ISA-L's levels 0 through 3 mapped to flate levels;
+	// streams with a dictionary use flate levels 7 through 9, the ones that
+	// search it.
+	// Writers cannot use Huffman options or a window smaller than 32K, and
+	// fail with ErrUnsupported if asked to; MemoryLevel and LevelBuffer are
+	// ignored. Readers accept data that refers back further than WindowBits,
+	// and report all corrupt deflate data as ErrInvalidBlock.
+	BackendGo
+)
+
+func (b BackendKind) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendISAL:
+		return "isa-l"
+	case BackendGo:
+		return "go"
+	}
+	return "invalid"
+}
+
+// Backend reports the backend that BackendAuto selects, and that Compress and
+// Decompress use: BackendISAL if the ISA-L library can be loaded, loading it
+// with LoadDefault if that has not happened yet, and BackendGo otherwise.
+// Binaries built without cgo always use BackendGo.
+func Backend() BackendKind {
+	if LoadDefault() != nil {
+		return BackendGo
+	}
+	return BackendISAL
+}
+
+// resolve returns the backend b stands for, or the error that keeps ISA-L
+// from being used.
+func (b BackendKind) resolve() (BackendKind, error) {
+	switch b {
+	case BackendAuto:
+		return Backend(), nil
+	case BackendISAL:
+		if err := LoadDefault(); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// writerImpl is the compressor behind a Writer, which handles the closed and
+// error states itself. Output goes to the io.Writer the implementation was
+// created or last reset with. The gzip header comes from the hdr of the first
+// write, flush or close of a stream; keeping no pointer to it lets a Writer
+// that is dropped unclosed be finalized.
+type writerImpl interface {
+	// write compresses p.
+	write(hdr *Header, p []byte) error
+	// flush writes out all pending output, dropping the match history if
+	// full is set.
+	flush(hdr *Header, full bool) error
+	// close finishes the stream with its trailer.
+	close(hdr *Header) error
+	// free releases what the stream holds outside the Go heap. It is safe
+	// to call more than once; reset sets the stream up again.
+	free()
+	// reset starts a new stream written to w, leaving the dictionary for
+	// the caller to apply.
+	reset(w io.Writer) error
+	// setDict makes d the dictionary of the stream just started and of the
+	// streams after each reset.
+	setDict(d *Dictionary) error
+	// applyDict hands the dictionary, if any, to the stream just started.
+	applyDict() error
+}
+
+// readerImpl is the decompressor behind a Reader, which handles the closed
+// and error states itself. Gzip headers are stored in the Header the
+// implementation was created with.
+type readerImpl interface {
+	// read decompresses into p. An error ends the stream.
+	read(p []byte) (int, error)
+	// setMultistream turns reading of further gzip members on or off.
+	setMultistream(ok bool)
+	// close releases the buffers of the stream.
+	close()
+	// reset starts reading a new stream from r, with Multistream on and the
+	// dictionary kept. For Gzip it reads the header.
+	reset(r io.Reader) error
+}
+
+func newWriterImpl(w io.Writer, level int, opts Opts) (writerImpl, error) {
+	b, err := opts.Backend.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if b == BackendGo {
+		return newGoWriter(w, level, opts)
+	}
+	return newISALWriter(w, level, opts)
+}
+
+func newReaderImpl(hdr *Header, r io.Reader, dict []byte, opts Opts) (readerImpl, error) {
+	b, err := opts.Backend.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if b == BackendGo {
+		return newGoReader(hdr, r, dict, opts)
+	}
+	return newISALReader(hdr, r, dict, opts)
+}
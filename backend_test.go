@@ -0,0 +1,388 @@
+package isal
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backends returns the backends the tests can run: the Go one, and ISA-L if
+// it can be loaded.
+func backends() []BackendKind {
+	if Backend() == BackendISAL {
+		return []BackendKind{BackendGo, BackendISAL}
+	}
+	return []BackendKind{BackendGo}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	return b
+}
+
+func TestBackend(t *testing.T) {
+	if b := Backend(); (b == BackendISAL) != (LoadDefault() == nil) || b.String() == "invalid" {
+		t.Fatalf("Testfail: Backend() = %v with LoadDefault() = %v", b, LoadDefault())
+	}
+	if _, err := NewWriterLevel(io.Discard, 1, Opts{Backend: BackendGo + 1}); err != errInvalidBackend {
+		t.Fatalf("Testfail: invalid backend returned %v", err)
+	}
+	if LoadDefault() != nil {
+		if _, err := NewWriterLevel(io.Discard, 1, Opts{Backend: BackendISAL}); err != LoadDefault() {
+			t.Fatalf("Testfail: BackendISAL without ISA-L returned %v", err)
+		}
+	}
+
+	// What compress/flate cannot do.
+	for _, o := range []Opts{{Huffman: HuffmanStatic}, {HuffmanTables: new(HuffmanTables)}, {WindowBits: 9}} {
+		o.Backend = BackendGo
+		if _, err := NewWriterLevel(io.Discard, 1, o); err != ErrUnsupported {
+			t.Errorf("Testfail: %+v returned %v", o, err)
+		}
+	}
+}
+
+// TestWriterFinalizer checks that Writers dropped without Close are
+// collected: their sinks, reachable only from them, get finalized.
+func TestWriterFinalizer(t *testing.T) {
+	for _, b := range backends() {
+		const n = 10
+		var collected atomic.Int32
+		for i := 0; i < n; i++ {
+			sink := new(bytes.Buffer)
+			runtime.SetFinalizer(sink, func(*bytes.Buffer) { collected.Add(1) })
+			w, err := NewWriterLevel(sink, 1, Opts{Backend: b})
+			if err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			w.Name = "unclosed"
+			w.Write([]byte("hello"))
+			if i%2 == 0 {
+				w.Close()
+			}
+		}
+		for i := 0; i < 100 && collected.Load() < n; i++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+		}
+		if c := collected.Load(); c < n {
+			t.Errorf("Testfail: %v: %d of %d dropped Writers were collected", b, c, n)
+		}
+	}
+}
+
+// TestEmptyExtra checks that a gzip extra field of no bytes survives a round
+// trip as a non-nil Extra, as it does with compress/gzip.
+func TestEmptyExtra(t *testing.T) {
+	g := new(bytes.Buffer)
+	gw := gzip.NewWriter(g)
+	gw.Extra = []byte{}
+	gw.Close()
+
+	for _, wb := range backends() {
+		b := new(bytes.Buffer)
+		w, _ := NewWriterLevel(b, 1, Opts{Backend: wb})
+		w.Extra = []byte{}
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if b.Bytes()[3]&gzipFlagExtra == 0 {
+			t.Fatalf("Testfail: %v wrote no extra field", wb)
+		}
+
+		// Without the field Extra stays nil.
+		plain := new(bytes.Buffer)
+		w, _ = NewWriterLevel(plain, 1, Opts{Backend: wb})
+		w.Close()
+		r, err := NewReader(plain, Opts{Backend: wb})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if r.Extra != nil {
+			t.Fatalf("Testfail: %v: no extra field read as %#v", wb, r.Extra)
+		}
+		for _, rb := range backends() {
+			for _, in := range [][]byte{b.Bytes(), g.Bytes()} {
+				r, err := NewReader(bytes.NewReader(in), Opts{Backend: rb})
+				if err != nil {
+					t.Fatal("Testfail:", err)
+				}
+				if r.Extra == nil || len(r.Extra) != 0 {
+					t.Fatalf("Testfail: written by %v, read by %v: Extra %#v", wb, rb, r.Extra)
+				}
+			}
+		}
+	}
+}
+
+func TestGoBackendFormats(t *testing.T) {
+	src := readFile(t, "e.txt")
+	dict := readFile(t, "gettysburg.txt")
+
+	for _, f := range []Format{Gzip, Zlib, Deflate, GzipNoHeader, ZlibNoHeader} {
+		for level := 0; level <= 3; level++ {
+			for _, d := range [][]byte{nil, dict} {
+				b := new(bytes.Buffer)
+				w, err := NewWriterDict(b, level, d, Opts{Format: f, Backend: BackendGo})
+				if err != nil {
+					t.Fatal("Testfail:", err)
+				}
+				w.Write(src[:len(src)/2])
+				w.Flush()
+				w.Write(src[len(src)/2:])
+				if err := w.Close(); err != nil {
+					t.Fatal("Testfail:", err)
+				}
+
+				// Every backend reads what the Go backend writes, and the
+				// other way around.
+				for _, rb := range backends() {
+					r, err := NewReaderDict(bytes.NewReader(b.Bytes()), d, Opts{Format: f, Backend: rb})
+					if err != nil {
+						t.Fatal("Testfail:", err)
+					}
+					if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, src) {
+						t.Fatalf("Testfail: format %d, level %d, dict %v, read by %v: %v", f, level, d != nil, rb, err)
+					}
+				}
+				for _, wb := range backends() {
+					b := new(bytes.Buffer)
+					w, _ := NewWriterDict(b, level, d, Opts{Format: f, Backend: wb})
+					w.Write(src)
+					w.Close()
+					r, err := NewReaderDict(b, d, Opts{Format: f, Backend: BackendGo})
+					if err != nil {
+						t.Fatal("Testfail:", err)
+					}
+					if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, src) {
+						t.Fatalf("Testfail: format %d, level %d, dict %v, written by %v: %v", f, level, d != nil, wb, err)
+					}
+				}
+			}
+		}
+	}
+
+	// The standard library reads the full formats.
+	for f, open := range map[Format]func(io.Reader) (io.Reader, error){
+		Gzip:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		Zlib:    func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		Deflate: func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil },
+	} {
+		b := new(bytes.Buffer)
+		w, _ := NewWriterLevel(b, 2, Opts{Format: f, Backend: BackendGo})
+		w.Write(src)
+		w.Close()
+		r, err := open(b)
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, src) {
+			t.Fatalf("Testfail: format %d: standard library read %v", f, err)
+		}
+	}
+
+	// A dictionary pays off at every level, also for input too short for
+	// compress/flate to search it at the levels without one.
+	record := dict[:100]
+	for level := 0; level <= 3; level++ {
+		var size [2]int
+		for i, d := range [][]byte{nil, dict} {
+			b := new(bytes.Buffer)
+			w, _ := NewWriterDict(b, level, d, Opts{Format: Deflate, Backend: BackendGo})
+			w.Write(record)
+			w.Close()
+			size[i] = b.Len()
+		}
+		if size[1] >= size[0] {
+			t.Errorf("Testfail: level %d: %d bytes with a dictionary, %d without", level, size[1], size[0])
+		}
+	}
+}
+
+func TestGoBackendStream(t *testing.T) {
+	src := readFile(t, "gettysburg.txt")
+	hdr := Header{
+		Comment: "comment",
+		Extra:   []byte("extra"),
+		ModTime: time.Unix(1e9, 0),
+		Name:    "name ü",
+		OS:      3,
+	}
+
+	// Two members, the first with a full header and a full flush.
+	b := new(bytes.Buffer)
+	w, err := NewWriterLevel(b, DEFAULT_LEVEL, Opts{Backend: BackendGo})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Header = hdr
+	w.Write(src)
+	w.FlushFull()
+	w.Write(src)
+	w.Close()
+	if err := w.Reset(b); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Write(src)
+	w.Close()
+	stream := b.Bytes()
+
+	g, err := gzip.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	g.Multistream(false)
+	if got, err := io.ReadAll(g); err != nil || len(got) != 2*len(src) || g.Name != hdr.Name || g.Comment != hdr.Comment ||
+		!bytes.Equal(g.Extra, hdr.Extra) || !g.ModTime.Equal(hdr.ModTime) || g.OS != hdr.OS {
+		t.Fatalf("Testfail: compress/gzip read %+v: %v", g.Header, err)
+	}
+
+	r, err := NewReader(bytes.NewReader(stream), Opts{Backend: BackendGo})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if r.Name != hdr.Name || r.Comment != hdr.Comment || !bytes.Equal(r.Extra, hdr.Extra) ||
+		!r.ModTime.Equal(hdr.ModTime) || r.OS != hdr.OS {
+		t.Fatalf("Testfail: header %+v", r.Header)
+	}
+	if got, err := io.ReadAll(r); err != nil || len(got) != 3*len(src) || r.Name != "" {
+		t.Fatalf("Testfail: multistream read %d bytes, header %+v: %v", len(got), r.Header, err)
+	}
+	r.Close()
+	if err := r.Close(); err != errReaderClosed {
+		t.Fatalf("Testfail: second Close returned %v", err)
+	}
+
+	if err := r.Reset(bytes.NewReader(stream)); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	r.Multistream(false)
+	if got, err := io.ReadAll(r); err != nil || len(got) != 2*len(src) {
+		t.Fatalf("Testfail: first member only: %d bytes, %v", len(got), err)
+	}
+
+	// Errors are reported like ISA-L's.
+	readAll := func(data []byte, f Format, dict []byte) error {
+		r, err := NewReaderDict(bytes.NewReader(data), dict, Opts{Format: f, Backend: BackendGo})
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+	bad := append([]byte(nil), stream...)
+	bad[len(bad)-5] ^= 1
+	if err := readAll(bad, Gzip, nil); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Testfail: corrupt trailer returned %v", err)
+	}
+	if err := readAll(stream[:len(stream)-3], Gzip, nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("Testfail: truncated stream returned %v", err)
+	}
+	if err := readAll(nil, Gzip, nil); err != io.EOF {
+		t.Errorf("Testfail: empty gzip input returned %v", err)
+	}
+	if err := readAll([]byte("\x1f\x8c\x08\x00\x00\x00\x00\x00\x00\xff"), Gzip, nil); !errors.Is(err, ErrInvalidWrapper) {
+		t.Errorf("Testfail: bad gzip magic returned %v", err)
+	}
+	var ce *CodecError
+	block := []byte{0x00, 0x05, 0x00, 0xfa, 0xff, 'h', 'e', 'l', 'l', 'o', 0x07}
+	if err := readAll(block, Deflate, nil); !errors.As(err, &ce) || ce.Err != ErrInvalidBlock || ce.Code != -1 {
+		t.Errorf("Testfail: invalid block returned %v", err)
+	}
+
+	b.Reset()
+	zw, _ := zlib.NewWriterLevelDict(b, 5, src)
+	zw.Write(src)
+	zw.Close()
+	if err := readAll(b.Bytes(), Zlib, nil); !errors.Is(err, ErrNeedDict) {
+		t.Errorf("Testfail: zlib stream without its dictionary returned %v", err)
+	}
+	if err := readAll(b.Bytes(), Zlib, src); err != nil {
+		t.Errorf("Testfail: zlib stream with a dictionary returned %v", err)
+	}
+}
+
+func TestGoBackendOneShot(t *testing.T) {
+	src := readFile(t, "e.txt")
+	for _, f := range []Format{Gzip, Zlib, Deflate} {
+		c, err := goCompress([]byte("prefix"), src, 1, f)
+		if err != nil || string(c[:6]) != "prefix" {
+			t.Fatalf("Testfail: format %d: %v", f, err)
+		}
+		got, err := goDecompress([]byte("prefix"), c[6:])
+		if err != nil || !bytes.Equal(got[6:], src) || string(got[:6]) != "prefix" {
+			t.Fatalf("Testfail: format %d: round trip %v", f, err)
+		}
+		if Backend() == BackendISAL {
+			if got, err := isalDecompress(nil, c[6:]); err != nil || !bytes.Equal(got, src) {
+				t.Fatalf("Testfail: format %d: ISA-L read %v", f, err)
+			}
+		}
+		if _, err := goDecompress(nil, c[6:len(c)-1]); err != io.ErrUnexpectedEOF {
+			t.Fatalf("Testfail: format %d: truncated input returned %v", f, err)
+		}
+
+		// Only one stream is decoded, and whatever follows it is an error
+		// rather than dropped.
+		one := c[6:]
+		for name, in := range map[string][]byte{
+			"two streams":    append(append([]byte(nil), one...), one...),
+			"trailing bytes": append(append([]byte(nil), one...), "garbage"...),
+		} {
+			if _, err := goDecompress(nil, in); err != errTrailingData {
+				t.Errorf("Testfail: format %d, %s: Go backend returned %v", f, name, err)
+			}
+			if Backend() == BackendISAL {
+				if _, err := isalDecompress(nil, in); err != errTrailingData {
+					t.Errorf("Testfail: format %d, %s: ISA-L returned %v", f, name, err)
+				}
+			}
+		}
+	}
+
+	// Dictionaries work without ISA-L's hashing.
+	d, err := NewDictionary(src[:1000], 2)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	b := new(bytes.Buffer)
+	w, err := NewWriterDictionary(b, d, Opts{Format: Zlib, Backend: BackendGo})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Write(src[:1000])
+	w.Close()
+	zr, err := zlib.NewReaderDict(b, src[:1000])
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, src[:1000]) {
+		t.Fatalf("Testfail: Dictionary round trip: %v", err)
+	}
+
+	d2, _ := NewDictionary(src[1000:2000], 2)
+	b.Reset()
+	if err := w.ResetDictionary(b, d2); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Write(src[1000:2000])
+	w.Close()
+	zr, err = zlib.NewReaderDict(b, src[1000:2000])
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, src[1000:2000]) {
+		t.Fatalf("Testfail: round trip after ResetDictionary: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package isal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	errOneShotSize  = errors.New("isal: buffer too large for Compress or Decompress")
+	errTrailingData = errors.New("isal: Decompress input continues after the end of the stream")
+)
+
+// maxRatio bounds how far deflate can expand data: a match of 258 bytes takes
+// at least two bits.
+const maxRatio = 1032
+
+// CompressBound returns the largest size the result of Compress can have for
+// n bytes of input, at any level and in any format. A dst with that much
+// spare capacity is never reallocated.
+func CompressBound(n int) int {
+	// Incompressible data is stored in blocks of at most 64K with a 5 byte
+	// header each; the wrapper adds at most 18 bytes (gzip).
+	return n + n>>12 + n>>14 + n>>25 + 13 + 18
+}
+
+// Compress appends the compressed form of src to dst and returns the
+// extended buffer, in one call into ISA-L. The level is 0 through 3 as for
+// NewWriterLevel; format is Gzip, Zlib or Deflate, with the default gzip and
+// zlib headers.
+//
+// dst is only reallocated if it has less than CompressBound(len(src)) bytes
+// of spare capacity, so passing the same buffer, emptied, to every call
+// compresses without allocating. Compress is safe for concurrent use. If
+// Backend reports BackendGo, compress/flate does the work, and allocates.
+func Compress(dst, src []byte, level int, format Format) ([]byte, error) {
+	if level < 0 || level > 3 {
+		return nil, ErrInvalidLevel
+	}
+	if format != Gzip && format != Zlib && format != Deflate {
+		return nil, errInvalidFormat
+	}
+	if CompressBound(len(src)) > math.MaxInt32 {
+		return nil, errOneShotSize
+	}
+	if Backend() == BackendGo {
+		return goCompress(dst, src, level, format)
+	}
+	return isalCompress(dst, src, level, format)
+}
+
+// Decompress appends the decompressed form of src to dst and returns the
+// extended buffer, in one call into ISA-L. src must hold exactly one gzip
+// member, zlib stream or raw deflate stream, as written by Compress; gzip and
+// zlib are told apart by their headers and anything else is taken to be raw
+// deflate. The gzip and zlib trailers are verified, and input left after the
+// end of the stream, such as a second gzip member, is an error.
+//
+// dst is only reallocated if the result does not fit in its spare capacity.
+// For gzip the size recorded in the trailer is used to size the result up
+// front; for the other formats Decompress may have to retry with a larger
+// buffer. Decompress is safe for concurrent use. If Backend reports
+// BackendGo, compress/flate does the work.
+func Decompress(dst, src []byte) ([]byte, error) {
+	if len(src) > math.MaxInt32 {
+		return nil, errOneShotSize
+	}
+	if Backend() == BackendGo {
+		return goDecompress(dst, src)
+	}
+	return isalDecompress(dst, src)
+}
+
+// compressStateless is Compress on a deflater, which deflates src in one
+// stateless call using levelBuf.
+func compressStateless(d deflater, levelBuf, dst, src []byte, level int, format Format) ([]byte, error) {
+	n := len(dst)
+	dst = grow(dst, CompressBound(len(src)))
+	out := dst[n:cap(dst)]
+	if len(out) > math.MaxInt32 {
+		out = out[:math.MaxInt32]
+	}
+
+	if ec := d.init(level, 0, len(levelBuf), levelBuf); ec != 0 {
+		return nil, deflateError(ec, 0)
+	}
+	nOut, ec := d.deflateStateless(src, out, format)
+	if ec != 0 {
+		return nil, deflateError(ec, 0)
+	}
+	return dst[:n+nOut], nil
+}
+
+// decompressStateless is Decompress on an inflater, which inflates src in one
+// stateless call per attempt.
+func decompressStateless(f inflater, dst, src []byte) ([]byte, error) {
+	format := detectFormat(src)
+
+	// A guess at the size of the result, which must be believable for src.
+	size := 4 * len(src)
+	if format == Gzip && len(src) >= 18 {
+		size = int(binary.LittleEndian.Uint32(src[len(src)-4:]))
+	}
+	if size > maxRatio*len(src) {
+		size = maxRatio * len(src)
+	}
+
+	n := len(dst)
+	for {
+		dst = grow(dst, size)
+		out := dst[n:cap(dst)]
+		if len(out) > math.MaxInt32 {
+			out = out[:math.MaxInt32]
+		}
+
+		nIn, nOut, ec := f.inflateStateless(src, out, format)
+		switch ec {
+		case codeDecompOK:
+			if nIn < len(src) {
+				return nil, errTrailingData
+			}
+			return dst[:n+nOut], nil
+		case codeOutOverflow:
+			if len(out) == math.MaxInt32 {
+				return nil, errOneShotSize
+			}
+			size = 2*len(out) + 1
+		case codeEndInput:
+			return nil, io.ErrUnexpectedEOF
+		default:
+			return nil, inflateError(ec, int64(nIn))
+		}
+	}
+}
+
+// goCompress is Compress on the Go backend.
+func goCompress(dst, src []byte, level int, format Format) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	z, err := newWriter(b, level, Opts{Format: format, Backend: BackendGo})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := z.Write(src); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// goDecompress is Decompress on the Go backend.
+func goDecompress(dst, src []byte) ([]byte, error) {
+	z, err := newReader(bytes.NewReader(src), nil, Opts{Format: detectFormat(src), Backend: BackendGo})
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	z.Multistream(false)
+	b := bytes.NewBuffer(dst)
+	if _, err := b.ReadFrom(z); err != nil {
+		return nil, err
+	}
+	if z.impl.(*goReader).in.n < int64(len(src)) {
+		return nil, errTrailingData
+	}
+	return b.Bytes(), nil
+}
+
+// grow returns b, or a copy of it, with at least extra bytes of spare capacity.
+func grow(b []byte, extra int) []byte {
+	if cap(b)-len(b) >= extra {
+		return b
+	}
+	nb := make([]byte, len(b), len(b)+extra)
+	copy(nb, b)
+	return nb
+}
+
+// detectFormat tells the formats Compress writes apart. No deflate stream
+// written by a conforming encoder starts like a zlib header: that would take
+// a stored block with nonzero padding bits.
+func detectFormat(src []byte) Format {
+	if len(src) >= 2 {
+		if src[0] == 0x1f && src[1] == 0x8b {
+			return Gzip
+		}
+		if src[0]&0x0f == 8 && src[0]>>4 <= 7 && (uint(src[0])<<8|uint(src[1]))%31 == 0 {
+			return Zlib
+		}
+	}
+	return Deflate
+}
@@ -0,0 +1,47 @@
+package isal
+
+//#include "igzip_lib.h"
+//#include <isal_native.h>
+import "C"
+
+import "sync"
+
+// statelessDeflaters pool the deflaters of Compress per level, so that the
+// level buffer is not allocated on every call.
+var statelessDeflaters [4]sync.Pool
+
+var statelessInflaters = sync.Pool{
+	New: func() interface{} {
+		return new(nativeInflater)
+	},
+}
+
+// statelessFlag returns the isal_zstream.gzip_flag for a Compress call, for
+// which ISA-L writes the default header itself.
+func (f Format) statelessFlag() C.int {
+	switch f {
+	case Gzip:
+		return C.IGZIP_GZIP
+	case Zlib:
+		return C.IGZIP_ZLIB
+	default:
+		return C.IGZIP_DEFLATE
+	}
+}
+
+// isalCompress is Compress on ISA-L.
+func isalCompress(dst, src []byte, level int, format Format) ([]byte, error) {
+	d, _ := statelessDeflaters[level].Get().(*nativeDeflater)
+	if d == nil {
+		d = &nativeDeflater{levelBuf: make([]byte, LevelBufferSize(level, MemoryDefault))}
+	}
+	defer statelessDeflaters[level].Put(d)
+	return compressStateless(d, d.levelBuf, dst, src, level, format)
+}
+
+// isalDecompress is Decompress on ISA-L.
+func isalDecompress(dst, src []byte) ([]byte, error) {
+	f := statelessInflaters.Get().(*nativeInflater)
+	defer statelessInflaters.Put(f)
+	return decompressStateless(f, dst, src)
+}
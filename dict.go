@@ -0,0 +1,86 @@
+package isal
+
+import (
+	"errors"
+	"hash/adler32"
+	"io"
+)
+
+var errDictLevel = errors.New("isal: Dictionary was built for a different compression level")
+
+// A Dictionary is a preset dictionary that ISA-L has already hashed for one
+// compression level. Building it costs about as much as NewWriterDict does;
+// starting a Writer from it afterwards only copies the result, which pays off
+// when many short streams share a dictionary. Without ISA-L the Dictionary
+// just holds dict for the Go backend, which cannot make use of the hashing.
+//
+// A Dictionary is never modified after NewDictionary returns and may be used
+// by any number of Writers from different goroutines at once.
+type Dictionary struct {
+	level    int
+	raw      []byte    // the dictionary itself, for the zlib header
+	id       uint32    // Adler-32 of raw
+	prepared *isaldict // raw as hashed by ISA-L, nil if it was not
+}
+
+// NewDictionary processes dict for Writers compressing at the given level.
+// Only the last 32K of dict can be referenced.
+func NewDictionary(dict []byte, level int) (*Dictionary, error) {
+	if level < 0 || level > 3 {
+		return nil, ErrInvalidLevel
+	}
+	d := &Dictionary{
+		level: level,
+		raw:   append([]byte(nil), dict...),
+	}
+	d.id = adler32.Checksum(d.raw)
+
+	// An empty dictionary has nothing to hash, and Writers ignore it.
+	if Backend() == BackendISAL && len(d.raw) > 0 {
+		if err := d.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Level returns the compression level d was built for.
+func (d *Dictionary) Level() int {
+	return d.level
+}
+
+// NewWriterDictionary is like NewWriterDict but starts from a Dictionary.
+// The Writer compresses at the level the Dictionary was built for.
+func NewWriterDictionary(w io.Writer, d *Dictionary, opts ...Opts) (*Writer, error) {
+	o, err := getOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	z, err := newWriter(w, d.level, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := z.impl.setDict(d); err != nil {
+		z.impl.free()
+		return nil, err
+	}
+	return z, nil
+}
+
+// ResetDictionary discards the Writer z's state and starts a new stream to w
+// that uses the Dictionary d, keeping the format and the native buffers of z.
+// d must have been built for the level z compresses at. Later calls to Reset
+// keep using d.
+func (z *Writer) ResetDictionary(w io.Writer, d *Dictionary) error {
+	if d.level != z.level {
+		return errDictLevel
+	}
+	if err := z.reset(w); err != nil {
+		return err
+	}
+	if err := z.impl.setDict(d); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
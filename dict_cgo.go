@@ -0,0 +1,36 @@
+package isal
+
+//#include "igzip_lib.h"
+//#include <isal_native.h>
+import "C"
+
+import "unsafe"
+
+type isaldict [unsafe.Sizeof(C.struct_isal_dict{})]C.char
+
+// prepare has ISA-L hash d.raw for d.level. A library without
+// isal_deflate_process_dict leaves d unprepared, to be hashed by each Writer.
+func (d *Dictionary) prepare() error {
+	if err := require(C.IG_FEATURE_DICT); err != nil {
+		return err
+	}
+	if require(C.IG_FEATURE_DICT_PROCESS) != nil {
+		return nil
+	}
+
+	// process_dict takes the hashing parameters from a stream set up for the
+	// level, so build one just for that.
+	var zs zstream
+	if ec := C.ig_isal_deflate_init(&zs[0], C.int(d.level), 0, C.int(LevelBufferSize(d.level, MemoryDefault)), 0); ec != 0 {
+		return deflateError(int(ec), 0)
+	}
+	defer C.ig_isal_deflate_end(&zs[0])
+
+	p := new(isaldict)
+	ec := C.ig_isal_deflate_process_dict(&zs[0], &p[0], bufPtr(d.raw, 0), C.int(len(d.raw)))
+	if ec != 0 {
+		return deflateError(int(ec), 0)
+	}
+	d.prepared = p
+	return nil
+}
@@ -0,0 +1,162 @@
+package isal
+
+// The return codes of ISA-L's compression calls, see igzip_lib.h.
+const (
+	codeCompOK            = 0
+	codeStatelessOverflow = -1
+	codeInvalidState      = -3
+	codeInvalidLevel      = -4
+	codeInvalidLevelBuf   = -5
+	codeInvalidFlush      = -7
+	codeInvalidParam      = -8
+	codeInvalidOperation  = -9
+)
+
+// The return codes of ISA-L's decompression calls. CodecError.Code holds
+// these for the Go backend too.
+const (
+	codeDecompOK          = 0
+	codeEndInput          = 1
+	codeOutOverflow       = 2
+	codeNameOverflow      = 3
+	codeCommentOverflow   = 4
+	codeExtraOverflow     = 5
+	codeNeedDict          = 6
+	codeInvalidBlock      = -1
+	codeInvalidSymbol     = -2
+	codeInvalidLookback   = -3
+	codeInvalidWrapper    = -4
+	codeUnsupportedMethod = -5
+	codeIncorrectChecksum = -6
+)
+
+// The flush modes of deflater.deflate.
+const (
+	noFlush   = 0
+	syncFlush = 1
+	fullFlush = 2
+)
+
+// The optional groups of ISA-L functions an engine may lack, the
+// IG_FEATURE_* bits of isal_native.h.
+const (
+	featureDict = 1 << iota
+	featureDictProcess
+	featureHuffman
+	featureZlibHeader
+)
+
+// deflater is one deflate stream of the ISA-L API, the engine an isalWriter
+// drives. Methods return ISA-L's codes. Apart from the level buffer and
+// Huffman tables given to init and setHufftables, no Go memory is kept
+// between calls.
+type deflater interface {
+	// require returns ErrUnsupported unless the engine has the feature.
+	require(feature int) error
+	// init sets up the stream. levelBuf is the level buffer, or nil to have
+	// one of levelBufSize bytes allocated outside the Go heap.
+	init(level, windowBits, levelBufSize int, levelBuf []byte) int
+	// setHufftables selects the code of mode, or t unless it is nil.
+	setHufftables(mode HuffmanMode, t *HuffmanTables) int
+	// setDict hands d to the stream just started.
+	setDict(d *Dictionary) int
+	// deflate compresses in into out using the flush mode, reporting how
+	// much of each it used. With end set it finishes the stream, and done
+	// reports that the trailer has been written.
+	deflate(in, out []byte, flush int, end bool, format Format) (nIn, nOut int, done bool, code int)
+	// deflateStateless compresses all of in into out as one stream, with
+	// the default gzip or zlib header, in a single call on a stream just
+	// set up by init.
+	deflateStateless(in, out []byte, format Format) (nOut, code int)
+	// writeGzipHeader writes a gzip header into out. name and comment are
+	// NUL-terminated or nil, extra is nil if there is no extra field. A
+	// positive code is the size needed if out is too small.
+	writeGzipHeader(out []byte, mtime uint32, os byte, extra, name, comment []byte) (n, code int)
+	// writeZlibHeader writes a zlib header into out, with the dictionary ID
+	// if hasDict is set.
+	writeZlibHeader(out []byte, info, level int, hasDict bool, dictID uint32) (n, code int)
+	// reset starts a new stream with the same settings.
+	reset()
+	// end releases a level buffer allocated by init.
+	end()
+}
+
+// inflater is one inflate stream of the ISA-L API, the engine an isalReader
+// drives. Methods return ISA-L's codes, and no Go memory is kept between
+// calls.
+type inflater interface {
+	require(feature int) error
+	init() int
+	// reset starts a new stream.
+	reset()
+	// setDict hands dict to the stream just started.
+	setDict(dict []byte) int
+	// inflate decompresses in into out, reporting how much of each it used.
+	// done is set at the end of the stream.
+	inflate(in, out []byte, format Format, windowBits int) (nIn, nOut int, done bool, code int)
+	// inflateStateless decompresses the one stream in holds into out in a
+	// single call, needing no init.
+	inflateStateless(in, out []byte, format Format) (nIn, nOut, code int)
+	// initGzipHeader prepares for a new gzip header.
+	initGzipHeader()
+	// readGzipHeader parses a gzip header from in. The name and comment are
+	// stored NUL-terminated; after an overflow code the call is repeated
+	// with the buffer grown and its contents kept.
+	readGzipHeader(in, extra, name, comment []byte) (nIn, code int)
+	// gzipHeader returns the fixed fields of the header just read. extraLen
+	// is -1 if the header has no extra field.
+	gzipHeader() (mtime uint32, os byte, extraLen int)
+}
+
+// deflateError converts a return code of the ISA-L compression calls into an
+// error. off is the number of uncompressed bytes consumed so far.
+func deflateError(r int, off int64) error {
+	var err error
+	switch r {
+	case codeCompOK:
+		return nil
+	case codeStatelessOverflow:
+		err = ErrStatelessOverflow
+	case codeInvalidState:
+		err = ErrInvalidState
+	case codeInvalidLevel:
+		err = ErrInvalidLevel
+	case codeInvalidLevelBuf:
+		err = ErrInvalidLevelBuf
+	case codeInvalidFlush:
+		err = ErrInvalidFlush
+	case codeInvalidParam:
+		err = ErrInvalidParam
+	case codeInvalidOperation:
+		err = ErrInvalidOperation
+	}
+	return &CodecError{Code: r, Offset: off, Err: err}
+}
+
+// inflateError converts a return code of the ISA-L decompression calls into
+// an error. off is the number of compressed bytes consumed so far.
+// ISAL_END_INPUT only asks for more input and is not an error.
+func inflateError(r int, off int64) error {
+	var err error
+	switch r {
+	case codeDecompOK, codeEndInput:
+		return nil
+	case codeOutOverflow:
+		err = ErrStatelessOverflow
+	case codeNeedDict:
+		err = ErrNeedDict
+	case codeInvalidBlock:
+		err = ErrInvalidBlock
+	case codeInvalidSymbol:
+		err = ErrInvalidSymbol
+	case codeInvalidLookback:
+		err = ErrInvalidLookback
+	case codeInvalidWrapper:
+		err = ErrInvalidWrapper
+	case codeUnsupportedMethod:
+		err = ErrUnsupportedMethod
+	case codeIncorrectChecksum:
+		err = ErrChecksum
+	}
+	return &CodecError{Code: r, Offset: off, Err: err}
+}
@@ -0,0 +1,393 @@
+package isal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// fault makes a call of a fake engine fail once the engine has consumed off
+// bytes of input.
+type fault struct {
+	call string
+	off  int64
+	code int
+}
+
+// fakeDeflater is a deflater that stores its input unchanged, so that the
+// Writer can be tested without ISA-L. The gzip header is the name followed by
+// a newline, and the stream ends with '$'. It records its calls and fails the
+// one its fault names.
+type fakeDeflater struct {
+	calls  []string
+	fault  fault
+	outMax int // most bytes written per deflate call, 0 for no limit
+	off    int64
+}
+
+func (d *fakeDeflater) record(call string, args ...interface{}) int {
+	d.calls = append(d.calls, strings.TrimSpace(call+" "+fmt.Sprint(args...)))
+	if d.fault.call == call && d.off >= d.fault.off {
+		return d.fault.code
+	}
+	return 0
+}
+
+func (d *fakeDeflater) require(feature int) error { return nil }
+
+func (d *fakeDeflater) init(level, windowBits, levelBufSize int, levelBuf []byte) int {
+	d.off = 0
+	return d.record("init")
+}
+
+func (d *fakeDeflater) setHufftables(mode HuffmanMode, t *HuffmanTables) int {
+	return d.record("setHufftables")
+}
+
+func (d *fakeDeflater) setDict(dict *Dictionary) int {
+	return d.record("setDict")
+}
+
+func (d *fakeDeflater) deflate(in, out []byte, flush int, end bool, format Format) (int, int, bool, int) {
+	n := len(in)
+	if d.fault.call == "deflate" && d.off+int64(n) > d.fault.off {
+		n = int(d.fault.off - d.off)
+	}
+	if d.outMax > 0 && n > d.outMax {
+		n = d.outMax
+	}
+	n = copy(out, in[:n])
+	d.off += int64(n)
+	if ec := d.record("deflate", n, flush, end); ec != 0 {
+		return n, n, false, ec
+	}
+	if end && len(out) > n {
+		out[n] = '$'
+		return n, n + 1, true, 0
+	}
+	return n, n, false, 0
+}
+
+func (d *fakeDeflater) deflateStateless(in, out []byte, format Format) (int, int) {
+	if ec := d.record("deflateStateless"); ec != 0 {
+		return 0, ec
+	}
+	if len(out) <= len(in) {
+		return 0, codeStatelessOverflow
+	}
+	return copy(out, append(in, '$')), 0
+}
+
+func (d *fakeDeflater) writeGzipHeader(out []byte, mtime uint32, os byte, extra, name, comment []byte) (int, int) {
+	if ec := d.record("writeGzipHeader"); ec != 0 {
+		return 0, ec
+	}
+	if len(name) > 0 {
+		name = name[:len(name)-1]
+	}
+	if len(out) < len(name)+1 {
+		return 0, len(name) + 1
+	}
+	return copy(out, append(name, '\n')), 0
+}
+
+func (d *fakeDeflater) writeZlibHeader(out []byte, info, level int, hasDict bool, dictID uint32) (int, int) {
+	return 0, d.record("writeZlibHeader")
+}
+
+func (d *fakeDeflater) reset() {
+	d.off = 0
+	d.record("reset")
+}
+
+func (d *fakeDeflater) end() {
+	d.record("end")
+}
+
+// fakeInflater is an inflater for the streams of fakeDeflater. With needDict
+// set every stream asks for a dictionary before its first byte.
+type fakeInflater struct {
+	calls    []string
+	fault    fault
+	needDict bool
+	haveDict bool
+	name     []byte // the header name read so far
+	off      int64
+}
+
+func (f *fakeInflater) record(call string) int {
+	f.calls = append(f.calls, call)
+	if f.fault.call == call && f.off >= f.fault.off {
+		return f.fault.code
+	}
+	return 0
+}
+
+func (f *fakeInflater) require(feature int) error { return nil }
+
+func (f *fakeInflater) init() int {
+	f.off, f.haveDict = 0, false
+	return f.record("init")
+}
+
+func (f *fakeInflater) reset() {
+	f.haveDict = false
+	f.record("reset")
+}
+
+func (f *fakeInflater) setDict(dict []byte) int {
+	f.haveDict = true
+	return f.record("setDict")
+}
+
+func (f *fakeInflater) inflate(in, out []byte, format Format, windowBits int) (int, int, bool, int) {
+	if f.needDict && !f.haveDict {
+		f.record("inflate")
+		return 0, 0, false, codeNeedDict
+	}
+	n, done := len(in), false
+	if i := bytes.IndexByte(in, '$'); i >= 0 {
+		n = i
+	}
+	if f.fault.call == "inflate" && f.off+int64(n) > f.fault.off {
+		n = int(f.fault.off - f.off)
+	}
+	n = copy(out, in[:n])
+	used := n
+	if used < len(in) && in[used] == '$' {
+		used, done = used+1, true
+	}
+	f.off += int64(used)
+	if ec := f.record("inflate"); ec != 0 {
+		return used, n, false, ec
+	}
+	if !done && used == len(in) {
+		return used, n, false, codeEndInput
+	}
+	return used, n, done, 0
+}
+
+func (f *fakeInflater) inflateStateless(in, out []byte, format Format) (int, int, int) {
+	i := bytes.IndexByte(in, '$')
+	if i < 0 {
+		return len(in), copy(out, in), codeEndInput
+	}
+	if len(out) < i {
+		return 0, 0, codeOutOverflow
+	}
+	f.off = int64(i)
+	if ec := f.record("inflateStateless"); ec != 0 {
+		return i, copy(out, in[:i]), ec
+	}
+	return i + 1, copy(out, in[:i]), 0
+}
+
+func (f *fakeInflater) initGzipHeader() {
+	f.name = f.name[:0]
+	f.record("initGzipHeader")
+}
+
+func (f *fakeInflater) readGzipHeader(in, extra, name, comment []byte) (int, int) {
+	i := bytes.IndexByte(in, '\n')
+	if i < 0 {
+		f.name = append(f.name, in...)
+		f.off += int64(len(in))
+		return len(in), codeEndInput
+	}
+	f.name = append(f.name, in[:i]...)
+	if len(f.name) >= len(name) {
+		f.off += int64(i)
+		f.record("readGzipHeader")
+		return i, codeNameOverflow
+	}
+	name[copy(name, f.name)] = 0
+	f.off += int64(i + 1)
+	return i + 1, f.record("readGzipHeader")
+}
+
+func (f *fakeInflater) gzipHeader() (uint32, byte, int) {
+	return 0, 0, -1
+}
+
+func newFakeWriter(t *testing.T, d *fakeDeflater, w io.Writer, opts Opts) *Writer {
+	t.Helper()
+	z := &Writer{level: 1}
+	impl, err := newDeflateWriter(d, w, 1, opts)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	z.impl = impl
+	return z
+}
+
+func newFakeReader(f *fakeInflater, r io.Reader, dict []byte, opts Opts) (*Reader, error) {
+	z := new(Reader)
+	impl, err := newInflateReader(f, &z.Header, r, dict, opts)
+	if err != nil {
+		return nil, err
+	}
+	z.impl = impl
+	return z, nil
+}
+
+func TestFakeWriter(t *testing.T) {
+	d := &fakeDeflater{outMax: 2}
+	b := new(bytes.Buffer)
+	w := newFakeWriter(t, d, b, Opts{})
+	w.Name = strings.Repeat("n", C_BUF_SIZE)
+	w.Write([]byte("hello"))
+	w.Flush()
+	if err := w.Close(); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if want := w.Name + "\nhello$"; b.String() != want {
+		t.Fatalf("Testfail: wrote %.20q, want %.20q", b.String(), want)
+	}
+	want := []string{"init", "writeGzipHeader", "writeGzipHeader",
+		"deflate 2 0 false", "deflate 2 0 false", "deflate 1 0 false",
+		"deflate 0 1 false", "deflate 0 0 true", "end"}
+	if !reflect.DeepEqual(d.calls, want) {
+		t.Fatalf("Testfail: calls %q, want %q", d.calls, want)
+	}
+
+	// Reset after Close sets the stream up again, before it just resets it.
+	d.calls = nil
+	w.Reset(io.Discard)
+	w.Reset(io.Discard)
+	w.Close()
+	if want := []string{"init", "reset", "writeGzipHeader", "deflate 0 0 true", "end"}; !reflect.DeepEqual(d.calls, want) {
+		t.Fatalf("Testfail: calls %q, want %q", d.calls, want)
+	}
+
+	// A failing deflate call ends the stream with a CodecError at the offset
+	// reached.
+	d = &fakeDeflater{fault: fault{call: "deflate", off: 3, code: codeInvalidState}}
+	w = newFakeWriter(t, d, io.Discard, Opts{Format: Deflate})
+	_, err := w.Write([]byte("hello"))
+	var ce *CodecError
+	if !errors.As(err, &ce) || ce.Code != codeInvalidState || ce.Offset != 3 || ce.Err != ErrInvalidState {
+		t.Fatalf("Testfail: failed deflate returned %v", err)
+	}
+	if _, err2 := w.Write([]byte("x")); err2 != err || w.Close() != err {
+		t.Fatal("Testfail: the error is not kept")
+	}
+	if d.calls[len(d.calls)-1] != "end" {
+		t.Fatalf("Testfail: Close did not free the stream: %q", d.calls)
+	}
+
+	d = &fakeDeflater{fault: fault{call: "init", code: codeInvalidLevelBuf}}
+	if _, err := newDeflateWriter(d, io.Discard, 1, Opts{}); !errors.Is(err, ErrInvalidLevelBuf) {
+		t.Fatalf("Testfail: failed init returned %v", err)
+	}
+	d = &fakeDeflater{fault: fault{call: "setHufftables", code: codeInvalidParam}}
+	if _, err := newDeflateWriter(d, io.Discard, 1, Opts{Huffman: HuffmanStatic}); !errors.Is(err, ErrInvalidParam) ||
+		d.calls[len(d.calls)-1] != "end" {
+		t.Fatalf("Testfail: failed setHufftables returned %v, calls %q", err, d.calls)
+	}
+}
+
+func TestFakeReader(t *testing.T) {
+	long := strings.Repeat("n", 1000)
+	stream := "a\nhello$" + long + "\nworld$"
+
+	// Members, long names and input arriving a byte at a time.
+	for _, in := range []io.Reader{strings.NewReader(stream), iotest.OneByteReader(strings.NewReader(stream))} {
+		f := new(fakeInflater)
+		r, err := newFakeReader(f, in, nil, Opts{})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if r.Name != "a" {
+			t.Fatalf("Testfail: header %q", r.Name)
+		}
+		if got, err := io.ReadAll(r); err != nil || string(got) != "helloworld" || r.Name != long {
+			t.Fatalf("Testfail: read %q with header %.10q: %v", got, r.Name, err)
+		}
+	}
+
+	f := new(fakeInflater)
+	r, _ := newFakeReader(f, strings.NewReader(stream), nil, Opts{})
+	r.Multistream(false)
+	if got, err := io.ReadAll(r); err != nil || string(got) != "hello" {
+		t.Fatalf("Testfail: first member only: %q, %v", got, err)
+	}
+
+	// ISAL_INVALID_BLOCK in the middle of the stream: the data before it is
+	// returned, then the error, and it stays.
+	f = &fakeInflater{fault: fault{call: "inflate", off: 4, code: codeInvalidBlock}}
+	r, _ = newFakeReader(f, strings.NewReader("hello world$"), nil, Opts{Format: Deflate})
+	p := make([]byte, 100)
+	n, err := r.Read(p)
+	var ce *CodecError
+	if string(p[:n]) != "hell" || !errors.As(err, &ce) || ce.Code != codeInvalidBlock || ce.Offset != 4 || ce.Err != ErrInvalidBlock {
+		t.Fatalf("Testfail: read %q: %v", p[:n], err)
+	}
+	if n, err2 := r.Read(p); n != 0 || err2 != err {
+		t.Fatalf("Testfail: second read returned %d, %v", n, err2)
+	}
+
+	// A zlib stream asking for the dictionary gets it, if there is one.
+	f = &fakeInflater{needDict: true}
+	r, _ = newFakeReader(f, strings.NewReader("hello$"), []byte("dict"), Opts{Format: Zlib})
+	if got, err := io.ReadAll(r); err != nil || string(got) != "hello" {
+		t.Fatalf("Testfail: read with a dictionary %q: %v", got, err)
+	}
+	if want := []string{"init", "inflate", "setDict", "inflate"}; !reflect.DeepEqual(f.calls[:4], want) {
+		t.Fatalf("Testfail: calls %q, want %q", f.calls, want)
+	}
+	f = &fakeInflater{needDict: true}
+	r, _ = newFakeReader(f, strings.NewReader("hello$"), nil, Opts{Format: Zlib})
+	if _, err := io.ReadAll(r); !errors.As(err, &ce) || ce.Code != codeNeedDict || ce.Err != ErrNeedDict {
+		t.Fatalf("Testfail: read without a dictionary: %v", err)
+	}
+
+	f = new(fakeInflater)
+	r, _ = newFakeReader(f, strings.NewReader("hello"), nil, Opts{Format: Deflate})
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Testfail: truncated stream returned %v", err)
+	}
+	if _, err := newFakeReader(f, strings.NewReader(""), nil, Opts{}); err != io.EOF {
+		t.Fatalf("Testfail: empty gzip input returned %v", err)
+	}
+	if _, err := newFakeReader(f, strings.NewReader("a"), nil, Opts{}); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Testfail: truncated header returned %v", err)
+	}
+	f = &fakeInflater{fault: fault{call: "readGzipHeader", code: codeInvalidWrapper}}
+	if _, err := newFakeReader(f, strings.NewReader("a\n"), nil, Opts{}); !errors.Is(err, ErrInvalidWrapper) {
+		t.Fatalf("Testfail: bad header returned %v", err)
+	}
+}
+
+func TestFakeOneShot(t *testing.T) {
+	d := new(fakeDeflater)
+	c, err := compressStateless(d, nil, []byte("x"), []byte("hello"), 1, Deflate)
+	if err != nil || string(c) != "xhello$" {
+		t.Fatalf("Testfail: compressed %q: %v", c, err)
+	}
+	if want := []string{"init", "deflateStateless"}; !reflect.DeepEqual(d.calls, want) {
+		t.Fatalf("Testfail: calls %q, want %q", d.calls, want)
+	}
+	d = &fakeDeflater{fault: fault{call: "init", code: codeInvalidLevelBuf}}
+	if _, err := compressStateless(d, nil, nil, []byte("hello"), 1, Deflate); !errors.Is(err, ErrInvalidLevelBuf) {
+		t.Fatalf("Testfail: failed init returned %v", err)
+	}
+
+	f := new(fakeInflater)
+	if got, err := decompressStateless(f, []byte("x"), []byte("hello$")); err != nil || string(got) != "xhello" {
+		t.Fatalf("Testfail: decompressed %q: %v", got, err)
+	}
+	if _, err := decompressStateless(f, nil, []byte("hello")); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Testfail: truncated stream returned %v", err)
+	}
+	f = &fakeInflater{fault: fault{call: "inflateStateless", code: codeIncorrectChecksum}}
+	_, err = decompressStateless(f, nil, []byte("hello$"))
+	var ce *CodecError
+	if !errors.As(err, &ce) || ce.Err != ErrChecksum || ce.Offset != 5 {
+		t.Fatalf("Testfail: bad checksum returned %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package isal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported by ISA-L while decompressing. Reader returns them wrapped
+// in a *CodecError; use errors.Is to test for them.
+var (
+	ErrInvalidBlock      = errors.New("isal: invalid deflate block")
+	ErrInvalidSymbol     = errors.New("isal: invalid deflate symbol")
+	ErrInvalidLookback   = errors.New("isal: invalid lookback distance")
+	ErrInvalidWrapper    = errors.New("isal: invalid gzip or zlib wrapper")
+	ErrUnsupportedMethod = errors.New("isal: unsupported compression method")
+	ErrNeedDict          = errors.New("isal: stream needs a dictionary")
+
+	// ErrChecksum is returned by Reader.Read when the gzip trailer (CRC-32
+	// and uncompressed size) or the zlib trailer (Adler-32) does not match
+	// the data that was read.
+	ErrChecksum = errors.New("isal: invalid checksum")
+)
+
+// Errors reported by ISA-L while compressing, wrapped in a *CodecError.
+var (
+	ErrInvalidFlush     = errors.New("isal: invalid flush mode")
+	ErrInvalidParam     = errors.New("isal: invalid parameter")
+	ErrInvalidOperation = errors.New("isal: invalid operation")
+	ErrInvalidState     = errors.New("isal: invalid stream state")
+	ErrInvalidLevel     = errors.New("isal: invalid compression level")
+	ErrInvalidLevelBuf  = errors.New("isal: invalid level buffer")
+)
+
+// ErrUnsupported is returned when a feature needs functions that the loaded
+// ISA-L library lacks, see Capabilities, or that BackendGo does not offer.
+var ErrUnsupported = errors.New("isal: not supported by the selected backend")
+
+// ErrStatelessOverflow is returned by the one-shot (stateless) calls when the
+// output buffer is too small for the result.
+var ErrStatelessOverflow = errors.New("isal: stateless output buffer overflow")
+
+// A CodecError is an error returned by ISA-L. Err is one of the sentinel
+// errors above, or nil for a return code this package does not know.
+type CodecError struct {
+	Code   int   // raw ISA-L return code
+	Offset int64 // input bytes consumed when the error was reported
+	Err    error
+}
+
+func (e *CodecError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("isal: unknown error %d at input offset %d", e.Code, e.Offset)
+	}
+	return fmt.Sprintf("%v (code %d) at input offset %d", e.Err, e.Code, e.Offset)
+}
+
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+// A LoadError is returned by Load, LoadDefault and the constructors when the
+// ISA-L library cannot be used.
+type LoadError struct {
+	Path   string // library that was loaded
+	Symbol string // function the library lacks, empty if it could not be opened
+	Msg    string // reason given by the dynamic linker
+}
+
+func (e *LoadError) Error() string {
+	if e.Symbol == "" {
+		return fmt.Sprintf("isal: cannot load %s: %s", e.Path, e.Msg)
+	}
+	return fmt.Sprintf("isal: %s lacks %s: %s", e.Path, e.Symbol, e.Msg)
+}
@@ -0,0 +1,502 @@
+package isal
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// goLevels maps ISA-L's compression levels 0 through 3 to the compress/flate
+// levels that come closest in speed and ratio.
+var goLevels = [4]int{1, 2, 4, 6}
+
+// goDictLevels replaces goLevels for streams with a preset dictionary: below
+// level 7 compress/flate may not look into the dictionary at all, and never
+// does for short inputs.
+var goDictLevels = [4]int{7, 7, 8, 9}
+
+// Gzip header flags, RFC 1952 section 2.3.1.
+const (
+	gzipFlagHCRC    = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// newChecksum returns the hash of the trailer of format f, nil for Deflate.
+func newChecksum(f Format) hash.Hash32 {
+	switch f {
+	case Gzip, GzipNoHeader:
+		return crc32.NewIEEE()
+	case Zlib, ZlibNoHeader:
+		return adler32.New()
+	}
+	return nil
+}
+
+// goWriter is the Writer backend built on compress/flate, which only does
+// the deflate stream: the headers and trailers are written here.
+type goWriter struct {
+	out     io.Writer
+	fw      *flate.Writer
+	fwDict  bool // fw was created with dict
+	level   int
+	format  Format
+	dict    *Dictionary // nil if none
+	sum     hash.Hash32 // checksum of the input, nil for Deflate
+	size    uint32      // input size modulo 2^32, for the gzip trailer
+	started bool        // the header has been written
+}
+
+func newGoWriter(w io.Writer, level int, opts Opts) (writerImpl, error) {
+	if opts.Huffman != HuffmanDefault || opts.HuffmanTables != nil {
+		return nil, ErrUnsupported
+	}
+	// compress/flate always refers back up to 32K.
+	if opts.WindowBits != 0 && opts.WindowBits != 15 {
+		return nil, ErrUnsupported
+	}
+	return &goWriter{
+		out:    w,
+		level:  level,
+		format: opts.Format,
+		sum:    newChecksum(opts.Format),
+	}, nil
+}
+
+// begin writes the header and readies the encoder at the start of a stream.
+func (z *goWriter) begin(hdr *Header) error {
+	if z.started {
+		return nil
+	}
+	z.started = true
+
+	var err error
+	switch z.format {
+	case Gzip:
+		err = z.writeGzipHeader(hdr)
+	case Zlib:
+		err = z.writeZlibHeader()
+	}
+	if err != nil {
+		return err
+	}
+	var dict []byte
+	if z.dict != nil {
+		dict = z.dict.raw
+	}
+	return z.encoder(dict)
+}
+
+// encoder readies z.fw for deflate data that may refer back into dict.
+// A flate.Writer can only be reset to the dictionary it was created with.
+func (z *goWriter) encoder(dict []byte) error {
+	if z.fw != nil && z.fwDict == (len(dict) > 0) {
+		z.fw.Reset(z.out)
+		return nil
+	}
+	level := goLevels[z.level]
+	if len(dict) > 0 {
+		level = goDictLevels[z.level]
+	}
+	fw, err := flate.NewWriterDict(z.out, level, dict)
+	if err != nil {
+		return err
+	}
+	z.fw, z.fwDict = fw, len(dict) > 0
+	return nil
+}
+
+// writeGzipHeader marshals h into a gzip header the way ISA-L does.
+func (z *goWriter) writeGzipHeader(h *Header) error {
+	b := make([]byte, 10, 64)
+	b[0], b[1], b[2] = 0x1f, 0x8b, 8
+	if h.ModTime.After(time.Unix(0, 0)) { // see isalWriter.writeGzipHeader
+		binary.LittleEndian.PutUint32(b[4:], uint32(h.ModTime.Unix()))
+	}
+	b[9] = h.OS
+	if h.Extra != nil {
+		if len(h.Extra) > 0xffff {
+			return errHeaderExtra
+		}
+		b[3] |= gzipFlagExtra
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(h.Extra)))
+		b = append(b, h.Extra...)
+	}
+	if h.Name != "" {
+		s, err := latin1String(h.Name)
+		if err != nil {
+			return err
+		}
+		b[3] |= gzipFlagName
+		b = append(b, s...)
+	}
+	if h.Comment != "" {
+		s, err := latin1String(h.Comment)
+		if err != nil {
+			return err
+		}
+		b[3] |= gzipFlagComment
+		b = append(b, s...)
+	}
+	_, err := z.out.Write(b)
+	return err
+}
+
+// writeZlibHeader writes the zlib header, announcing the 32K window.
+func (z *goWriter) writeZlibHeader() error {
+	cmf := byte(7<<4 | 8)
+	flg := byte(z.level << 6)
+	if z.dict != nil {
+		flg |= 1 << 5
+	}
+	flg += byte((31 - (uint(cmf)<<8|uint(flg))%31) % 31)
+	b := []byte{cmf, flg}
+	if z.dict != nil {
+		b = binary.BigEndian.AppendUint32(b, z.dict.id)
+	}
+	_, err := z.out.Write(b)
+	return err
+}
+
+func (z *goWriter) write(hdr *Header, p []byte) error {
+	if err := z.begin(hdr); err != nil {
+		return err
+	}
+	if z.sum != nil {
+		z.sum.Write(p)
+	}
+	z.size += uint32(len(p))
+	_, err := z.fw.Write(p)
+	return err
+}
+
+func (z *goWriter) flush(hdr *Header, full bool) error {
+	if err := z.begin(hdr); err != nil {
+		return err
+	}
+	if err := z.fw.Flush(); err != nil {
+		return err
+	}
+	if full {
+		// compress/flate has no full flush. A fresh encoder knows no history
+		// and can carry on from the byte boundary Flush ended on.
+		return z.encoder(nil)
+	}
+	return nil
+}
+
+func (z *goWriter) close(hdr *Header) error {
+	if err := z.begin(hdr); err != nil {
+		return err
+	}
+	if err := z.fw.Close(); err != nil {
+		return err
+	}
+	var b []byte
+	switch z.format {
+	case Gzip, GzipNoHeader:
+		b = binary.LittleEndian.AppendUint32(b, z.sum.Sum32())
+		b = binary.LittleEndian.AppendUint32(b, z.size)
+	case Zlib, ZlibNoHeader:
+		b = binary.BigEndian.AppendUint32(b, z.sum.Sum32())
+	default:
+		return nil
+	}
+	_, err := z.out.Write(b)
+	return err
+}
+
+// free has nothing to release: all of a goWriter lives on the Go heap.
+func (z *goWriter) free() {}
+
+func (z *goWriter) reset(w io.Writer) error {
+	z.out = w
+	z.started = false
+	if z.sum != nil {
+		z.sum.Reset()
+	}
+	z.size = 0
+	return nil
+}
+
+func (z *goWriter) setDict(d *Dictionary) error {
+	z.dict = d
+	if len(d.raw) == 0 {
+		z.dict = nil
+	}
+	// The encoder keeps the dictionary it was made with.
+	z.fw = nil
+	return nil
+}
+
+// applyDict has nothing to do: begin hands the dictionary to the encoder.
+func (z *goWriter) applyDict() error {
+	return nil
+}
+
+// countingReader is the input of a goReader. The buffering keeps
+// compress/flate from reading past the end of the deflate data, which is
+// followed by the trailer, and the count gives CodecError its offset.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// readString reads a NUL-terminated header field, terminator included.
+func (c *countingReader) readString() ([]byte, error) {
+	b, err := c.r.ReadBytes(0)
+	c.n += int64(len(b))
+	return b, err
+}
+
+// goReader is the Reader backend built on compress/flate.
+type goReader struct {
+	hdr         *Header
+	in          countingReader
+	fr          io.ReadCloser // nil until the first member
+	format      Format
+	dict        []byte // preset dictionary, nil if none
+	multistream bool
+	inMember    bool        // fr is set up for the current member
+	sum         hash.Hash32 // checksum of the output, nil for Deflate
+	size        uint32      // output size modulo 2^32, for the gzip trailer
+}
+
+func newGoReader(hdr *Header, r io.Reader, dict []byte, opts Opts) (readerImpl, error) {
+	z := &goReader{
+		hdr:    hdr,
+		in:     countingReader{r: bufio.NewReader(r)},
+		format: opts.Format,
+		dict:   dict,
+		sum:    newChecksum(opts.Format),
+	}
+	if err := z.reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *goReader) reset(r io.Reader) error {
+	z.in.r.Reset(r)
+	z.in.n = 0
+	z.multistream = true
+	z.inMember = false
+	if z.format == Gzip {
+		return z.readGzipHeader()
+	}
+	return nil
+}
+
+func (z *goReader) setMultistream(ok bool) {
+	z.multistream = ok
+}
+
+func (z *goReader) close() {
+	z.in.r.Reset(nil)
+}
+
+func (z *goReader) read(p []byte) (int, error) {
+	for {
+		if !z.inMember {
+			if err := z.beginMember(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := z.fr.Read(p)
+		if z.sum != nil {
+			z.sum.Write(p[:n])
+		}
+		z.size += uint32(n)
+
+		if err == io.EOF {
+			z.inMember = false
+			if err := z.readTrailer(); err != nil {
+				return n, err
+			}
+			// A gzip stream may be followed by further members, each with
+			// its own header.
+			if z.format != Gzip || !z.multistream {
+				return n, io.EOF
+			}
+			if err := z.readGzipHeader(); err != nil {
+				return n, err
+			}
+		} else if err != nil {
+			if _, ok := err.(flate.CorruptInputError); ok {
+				err = z.error(codeInvalidBlock, ErrInvalidBlock)
+			}
+			return n, err
+		}
+		if n > 0 || len(p) == 0 {
+			return n, nil
+		}
+	}
+}
+
+// beginMember sets up the decompressor for the deflate data after the
+// header, which is only read here for Zlib.
+func (z *goReader) beginMember() error {
+	dict := z.dict
+	if z.format == Zlib {
+		var err error
+		if dict, err = z.readZlibHeader(); err != nil {
+			return err
+		}
+	}
+	if z.fr == nil {
+		z.fr = flate.NewReaderDict(&z.in, dict)
+	} else if err := z.fr.(flate.Resetter).Reset(&z.in, dict); err != nil {
+		return err
+	}
+	if z.sum != nil {
+		z.sum.Reset()
+	}
+	z.size = 0
+	z.inMember = true
+	return nil
+}
+
+// error returns a CodecError at the current input offset.
+func (z *goReader) error(code int, err error) error {
+	return &CodecError{Code: code, Offset: z.in.n, Err: err}
+}
+
+// full fills b from the input. The stream must go on, so running out of
+// input is io.ErrUnexpectedEOF.
+func (z *goReader) full(b []byte) error {
+	_, err := io.ReadFull(&z.in, b)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readGzipHeader parses the header of a gzip member into z.hdr. It returns
+// io.EOF if the input ends before the first byte of a header.
+func (z *goReader) readGzipHeader() error {
+	var b [10]byte
+	if _, err := io.ReadFull(&z.in, b[:]); err != nil {
+		return err
+	}
+	if b[0] != 0x1f || b[1] != 0x8b {
+		return z.error(codeInvalidWrapper, ErrInvalidWrapper)
+	}
+	if b[2] != 8 {
+		return z.error(codeUnsupportedMethod, ErrUnsupportedMethod)
+	}
+	flg := b[3]
+	if flg&0xe0 != 0 {
+		return z.error(codeInvalidWrapper, ErrInvalidWrapper)
+	}
+	crc := crc32.ChecksumIEEE(b[:])
+
+	h := Header{OS: b[9]}
+	if t := binary.LittleEndian.Uint32(b[4:]); t > 0 {
+		h.ModTime = time.Unix(int64(t), 0)
+	}
+	if flg&gzipFlagExtra != 0 {
+		if err := z.full(b[:2]); err != nil {
+			return err
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, b[:2])
+		// Non-nil even if empty, see isalReader.setHeader.
+		h.Extra = make([]byte, binary.LittleEndian.Uint16(b[:]))
+		if err := z.full(h.Extra); err != nil {
+			return err
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, h.Extra)
+	}
+	for _, f := range []struct {
+		flag byte
+		s    *string
+	}{{gzipFlagName, &h.Name}, {gzipFlagComment, &h.Comment}} {
+		if flg&f.flag == 0 {
+			continue
+		}
+		s, err := z.in.readString()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, s)
+		*f.s = decodeLatin1(s)
+	}
+	if flg&gzipFlagHCRC != 0 {
+		if err := z.full(b[:2]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint16(b[:]) != uint16(crc) {
+			return z.error(codeIncorrectChecksum, ErrChecksum)
+		}
+	}
+	*z.hdr = h
+	return nil
+}
+
+// readZlibHeader parses the zlib header and returns the dictionary the
+// stream needs, nil if none.
+func (z *goReader) readZlibHeader() ([]byte, error) {
+	var b [4]byte
+	if err := z.full(b[:2]); err != nil {
+		return nil, err
+	}
+	if (uint(b[0])<<8|uint(b[1]))%31 != 0 || b[0]>>4 > 7 {
+		return nil, z.error(codeInvalidWrapper, ErrInvalidWrapper)
+	}
+	if b[0]&0x0f != 8 {
+		return nil, z.error(codeUnsupportedMethod, ErrUnsupportedMethod)
+	}
+	if b[1]&(1<<5) == 0 {
+		return nil, nil
+	}
+	if err := z.full(b[:4]); err != nil {
+		return nil, err
+	}
+	if len(z.dict) == 0 {
+		return nil, z.error(codeNeedDict, ErrNeedDict)
+	}
+	return z.dict, nil
+}
+
+// readTrailer verifies the trailer of the member just decompressed.
+func (z *goReader) readTrailer() error {
+	var b [8]byte
+	switch z.format {
+	case Gzip, GzipNoHeader:
+		if err := z.full(b[:8]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(b[:]) != z.sum.Sum32() || binary.LittleEndian.Uint32(b[4:]) != z.size {
+			return z.error(codeIncorrectChecksum, ErrChecksum)
+		}
+	case Zlib, ZlibNoHeader:
+		if err := z.full(b[:4]); err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(b[:]) != z.sum.Sum32() {
+			return z.error(codeIncorrectChecksum, ErrChecksum)
+		}
+	}
+	return nil
+}
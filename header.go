@@ -0,0 +1,64 @@
+package isal
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+var errHeaderString = errors.New("isal: non-Latin-1 header string")
+var errHeaderExtra = errors.New("isal: Extra data is too large")
+
+// The gzip file stores a header giving metadata about the compressed file.
+// That header is exposed as the fields of the Writer and Reader structs, the
+// same way compress/gzip does it.
+//
+// Strings must be UTF-8 encoded and may only contain Unicode code points
+// U+0001 through U+00FF, due to limitations of the GZIP file format.
+type Header struct {
+	Comment string    // comment
+	Extra   []byte    // "extra data"
+	ModTime time.Time // modification time
+	Name    string    // file name
+	OS      byte      // operating system type
+}
+
+// headerOSUnknown is the OS byte written when the caller doesn't set one.
+const headerOSUnknown = 255
+
+// latin1String returns s as a NUL-terminated ISO 8859-1 string, the encoding
+// the gzip header uses for the file name and comment.
+func latin1String(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s)+1)
+	for _, v := range s {
+		if v == 0 || v > 0xff {
+			return nil, errHeaderString
+		}
+		b = append(b, byte(v))
+	}
+	return append(b, 0), nil
+}
+
+// decodeLatin1 converts an ISO 8859-1 string, up to its NUL terminator, to
+// UTF-8.
+func decodeLatin1(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	// ASCII needs no conversion, and an empty string no allocation.
+	ascii := true
+	for _, v := range b {
+		if v >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return string(b)
+	}
+	r := make([]rune, len(b))
+	for i, v := range b {
+		r[i] = rune(v)
+	}
+	return string(r)
+}
@@ -0,0 +1,39 @@
+package isal
+
+import "errors"
+
+// ErrHuffmanTables is returned by HuffmanTables.UnmarshalBinary for data that
+// is not a valid profile for this build of the package.
+var ErrHuffmanTables = errors.New("isal: invalid or incompatible Huffman tables profile")
+
+// HuffmanMode selects which of ISA-L's built-in Huffman codes a Writer
+// compresses with, see Opts.Huffman.
+type HuffmanMode int
+
+const (
+	// HuffmanDefault is ISA-L's default code, tuned for typical data.
+	HuffmanDefault HuffmanMode = iota
+	// HuffmanStatic is the fixed code defined by RFC 1951. Blocks using it
+	// carry no code description, which makes it the smallest choice for
+	// very short messages.
+	HuffmanStatic
+)
+
+// A Histogram counts the deflate symbols found in sample data. It is used to
+// build HuffmanTables suited to data like the samples. The zero value is an
+// empty Histogram ready to use.
+type Histogram struct {
+	h isalhistogram
+}
+
+// HuffmanTables hold a Huffman code for a Writer to compress with, in place
+// of the one ISA-L uses by default. Build them from a Histogram of data like
+// the data to be compressed and select them with Opts.HuffmanTables.
+//
+// Only level 0 compresses with the tables; at levels 1 through 3 ISA-L builds
+// its own code for each block and the tables make no difference.
+// HuffmanTables are never modified once built and may be shared by any number
+// of Writers.
+type HuffmanTables struct {
+	t isalhufftables
+}
@@ -0,0 +1,152 @@
+package isal
+
+//#include "igzip_lib.h"
+//#include <isal_native.h>
+import "C"
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"unsafe"
+)
+
+type isalhistogram [unsafe.Sizeof(C.struct_isal_huff_histogram{})]C.char
+type isalhufftables [unsafe.Sizeof(C.struct_isal_hufftables{})]C.char
+
+// hufftableType returns the isal_deflate_set_hufftables type for m.
+func (m HuffmanMode) hufftableType() C.int {
+	if m == HuffmanStatic {
+		return C.IGZIP_HUFFTABLE_STATIC
+	}
+	return C.IGZIP_HUFFTABLE_DEFAULT
+}
+
+// Add runs ISA-L's match finder over sample and counts the symbols it
+// produces. Add can be called any number of times; matches are only found
+// within a single sample.
+func (h *Histogram) Add(sample []byte) error {
+	if err := LoadDefault(); err != nil {
+		return err
+	}
+	if err := require(C.IG_FEATURE_HUFFMAN); err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return nil
+	}
+	C.ig_isal_update_histogram((*C.uint8_t)(unsafe.Pointer(&sample[0])), C.int(len(sample)), &h.h[0])
+	return nil
+}
+
+// NewHuffmanTables builds a Huffman code from h. Every literal gets a code,
+// so the tables can compress any input, not just data like the samples.
+func NewHuffmanTables(h *Histogram) (*HuffmanTables, error) {
+	return newHuffmanTables(h, 0)
+}
+
+// NewHuffmanTablesSubset is like NewHuffmanTables but leaves out the literals
+// that never occur in h. That gives shorter codes, but the tables must then
+// only be used on data made of the literals h has seen.
+func NewHuffmanTablesSubset(h *Histogram) (*HuffmanTables, error) {
+	return newHuffmanTables(h, 1)
+}
+
+func newHuffmanTables(h *Histogram, subset C.int) (*HuffmanTables, error) {
+	if err := LoadDefault(); err != nil {
+		return nil, err
+	}
+	if err := require(C.IG_FEATURE_HUFFMAN); err != nil {
+		return nil, err
+	}
+	t := new(HuffmanTables)
+	// create_hufftables may write to the histogram, so work on a copy.
+	hist := h.h
+	if ec := C.ig_isal_create_hufftables(&t.t[0], &hist[0], subset); ec != 0 {
+		return nil, deflateError(int(ec), 0)
+	}
+	return t, nil
+}
+
+// ptr returns the tables for ig_isal_deflate, nil for ISA-L's own.
+func (t *HuffmanTables) ptr() *C.char {
+	if t == nil {
+		return nil
+	}
+	return &t.t[0]
+}
+
+// The serialized form of HuffmanTables, all integers little-endian:
+//
+//	magic      [4]byte "IGHT"
+//	version    uint16
+//	byte order uint8, 1 little-endian, 2 big-endian
+//	reserved   uint8
+//	sizeof(struct isal_hufftables), IGZIP_DIST_TABLE_SIZE,
+//	IGZIP_LEN_TABLE_SIZE, IGZIP_LIT_TABLE_SIZE as uint32
+//	struct isal_hufftables, in the byte order above
+//	CRC-32 (IEEE) of everything before it, uint32
+//
+// The table sizes pin down the layout of the struct, which depends on how
+// ISA-L was configured (LONGER_HUFFTABLES); a profile is only accepted if it
+// matches the layout this package was built with.
+const (
+	huffMagic      = "IGHT"
+	huffVersion    = 1
+	huffHeaderSize = 24
+)
+
+// huffLayout describes struct isal_hufftables as this package sees it.
+var huffLayout = [4]uint32{
+	uint32(unsafe.Sizeof(C.struct_isal_hufftables{})),
+	C.IGZIP_DIST_TABLE_SIZE,
+	C.IGZIP_LEN_TABLE_SIZE,
+	C.IGZIP_LIT_TABLE_SIZE,
+}
+
+// nativeByteOrder returns the byte order marker of the running machine.
+func nativeByteOrder() byte {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return 1
+	}
+	return 2
+}
+
+// MarshalBinary encodes t as a versioned, checksummed profile that
+// UnmarshalBinary can load without retraining. The profile can be loaded by
+// builds of this package on machines of the same byte order whose ISA-L
+// headers give struct isal_hufftables the same layout.
+func (t *HuffmanTables) MarshalBinary() ([]byte, error) {
+	b := make([]byte, huffHeaderSize, huffHeaderSize+len(t.t)+4)
+	copy(b, huffMagic)
+	binary.LittleEndian.PutUint16(b[4:], huffVersion)
+	b[6] = nativeByteOrder()
+	for i, v := range huffLayout {
+		binary.LittleEndian.PutUint32(b[8+4*i:], v)
+	}
+	b = append(b, unsafe.Slice((*byte)(unsafe.Pointer(&t.t[0])), len(t.t))...)
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b)), nil
+}
+
+// UnmarshalBinary loads a profile written by MarshalBinary into t. It fails
+// with ErrHuffmanTables if the data is corrupt, has an unknown version or
+// was written for a different layout of the tables.
+func (t *HuffmanTables) UnmarshalBinary(data []byte) error {
+	if len(data) != huffHeaderSize+len(t.t)+4 || string(data[:4]) != huffMagic {
+		return ErrHuffmanTables
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return ErrHuffmanTables
+	}
+	if binary.LittleEndian.Uint16(data[4:]) != huffVersion || data[6] != nativeByteOrder() {
+		return ErrHuffmanTables
+	}
+	for i, v := range huffLayout {
+		if binary.LittleEndian.Uint32(data[8+4*i:]) != v {
+			return ErrHuffmanTables
+		}
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&t.t[0])), len(t.t)), body[huffHeaderSize:])
+	return nil
+}
@@ -0,0 +1,475 @@
+package isal
+
+import (
+	"errors"
+	"hash/adler32"
+	"io"
+	"runtime"
+)
+
+var errReaderClosed = errors.New("Reader is closed")
+var errWriterClosed = errors.New("Writer is closed")
+
+var errInvalidFormat = errors.New("isal: invalid format")
+var errTooManyOpts = errors.New("isal: at most one Opts argument may be given")
+var errInvalidHuffman = errors.New("isal: invalid Huffman options")
+var errInvalidWindowBits = errors.New("isal: WindowBits must be between 8 and 15")
+var errInvalidMemoryLevel = errors.New("isal: invalid MemoryLevel")
+var errLevelBufferSize = errors.New("isal: LevelBuffer is too small for the level")
+var errInvalidBackend = errors.New("isal: invalid Backend")
+
+const (
+	D_BUF_SIZE    = 640 * 1024
+	C_BUF_SIZE    = 128 * 1024
+	DEFAULT_LEVEL = 0
+
+	// Deprecated: the format is chosen per Writer and Reader through
+	// Opts.Format. This constant has no effect.
+	HAS_GZIP_HEADER = 1
+)
+
+// Format is the wrapper around the deflate stream produced by a Writer or
+// expected by a Reader.
+type Format int
+
+const (
+	// Gzip is the RFC 1952 gzip format with a CRC-32 trailer.
+	Gzip Format = iota
+	// Zlib is the RFC 1950 zlib format with an Adler-32 trailer.
+	Zlib
+	// Deflate is a raw RFC 1951 stream with no header or trailer, as used by
+	// zip entries and WebSocket permessage-deflate.
+	Deflate
+	// GzipNoHeader is the gzip CRC-32/ISIZE trailer without the gzip header.
+	GzipNoHeader
+	// ZlibNoHeader is the zlib Adler-32 trailer without the zlib header.
+	ZlibNoHeader
+)
+
+// Opts holds the optional settings of NewWriterLevel and NewReader.
+type Opts struct {
+	// Format is the stream wrapper to write or expect. The zero value is Gzip.
+	Format Format
+
+	// Huffman selects one of ISA-L's built-in Huffman codes for a Writer.
+	// The zero value is HuffmanDefault. Readers ignore it.
+	Huffman HuffmanMode
+
+	// HuffmanTables, if not nil, replace the Huffman code a Writer
+	// compresses with at level 0, see HuffmanTables; Huffman must then be
+	// left at HuffmanDefault. Readers ignore it.
+	HuffmanTables *HuffmanTables
+
+	// WindowBits is the base two logarithm of the window size, 8 through
+	// 15. A Writer then only refers back that far, and its zlib header
+	// announces the window. A Reader rejects data that refers back further
+	// but keeps the same history buffer whatever the window, so a smaller
+	// one saves no memory. The zero value is the full 32K window (15).
+	WindowBits int
+
+	// MemoryLevel sizes the buffer a Writer at levels 1 through 3 works in.
+	// Smaller buffers cost some compression ratio. Readers ignore it.
+	MemoryLevel MemoryLevel
+
+	// LevelBuffer, if not nil, is used as that buffer instead of memory
+	// allocated in C, and its length takes the place of MemoryLevel. It must
+	// be at least LevelBufferSize(level, MemoryMin) bytes long and must not
+	// be used for anything else until the Writer is closed. Readers ignore
+	// it.
+	LevelBuffer []byte
+
+	// Backend selects the implementation behind the Writer or Reader. The
+	// zero value, BackendAuto, uses ISA-L if the library can be loaded and
+	// compress/flate otherwise.
+	Backend BackendKind
+}
+
+// getOpts returns the single Opts passed to a constructor, or the defaults.
+func getOpts(opts []Opts) (Opts, error) {
+	var o Opts
+	switch len(opts) {
+	case 0:
+	case 1:
+		o = opts[0]
+	default:
+		return o, errTooManyOpts
+	}
+	if o.Format < Gzip || o.Format > ZlibNoHeader {
+		return o, errInvalidFormat
+	}
+	if o.MemoryLevel < MemoryDefault || o.MemoryLevel > MemoryExtraLarge {
+		return o, errInvalidMemoryLevel
+	}
+	if o.WindowBits != 0 && (o.WindowBits < 8 || o.WindowBits > 15) {
+		return o, errInvalidWindowBits
+	}
+	if o.Huffman < HuffmanDefault || o.Huffman > HuffmanStatic ||
+		(o.Huffman != HuffmanDefault && o.HuffmanTables != nil) {
+		return o, errInvalidHuffman
+	}
+	if o.Backend < BackendAuto || o.Backend > BackendGo {
+		return o, errInvalidBackend
+	}
+	return o, nil
+}
+
+// MemoryLevel is one of the level buffer sizes ISA-L suggests for each
+// compression level, see Opts.MemoryLevel.
+type MemoryLevel int
+
+const (
+	// MemoryDefault is ISA-L's default, the same as MemoryLarge.
+	MemoryDefault MemoryLevel = iota
+	MemoryMin
+	MemorySmall
+	MemoryMedium
+	MemoryLarge
+	MemoryExtraLarge
+)
+
+// levelBufSizes holds ISAL_DEF_LVLn_* from igzip_lib.h by level and
+// MemoryLevel. They are spelled out so that builds without cgo have them too.
+var levelBufSizes = [4][6]int{
+	{0, 0, 0, 0, 0, 0},
+	{282624, 24576, 86016, 151552, 282624, 544768},
+	{331776, 73728, 135168, 200704, 331776, 593920},
+	{348160, 90112, 151552, 217088, 348160, 610304},
+}
+
+// LevelBufferSize returns the size of the level buffer ISA-L suggests for
+// the compression level and memory level, or 0 if either is invalid. Level 0
+// needs no buffer.
+func LevelBufferSize(level int, m MemoryLevel) int {
+	if level < 0 || level > 3 || m < MemoryDefault || m > MemoryExtraLarge {
+		return 0
+	}
+	return levelBufSizes[level][m]
+}
+
+// LIB_LOADED is set to 1 once the library has been loaded.
+//
+// Deprecated: reading it is not safe while the library is being loaded from
+// another goroutine. Call LoadDefault or Ready instead.
+var LIB_LOADED = 0
+
+// Ready reports whether the ISA-L library could be loaded, loading it with
+// LoadDefault if that has not happened yet. Use LoadDefault to learn why
+// loading failed.
+func Ready() bool {
+	return LoadDefault() == nil
+}
+
+// Reader is a gzip/zlib/flate reader. It implements io.ReadCloser.  Calling
+// Close is optional, though strongly recommended: a Reader holds nothing
+// outside the Go heap, and Close returns its input buffer to a pool for the
+// next Reader.
+type Reader struct {
+	Header // valid after NewReader or Reader.Reset
+	impl   readerImpl
+	closed bool
+	err    error
+}
+
+// NewReader creates a gzip/flate reader. There can be at most one options arg.
+// Without one the input is expected to be gzip; Opts.Format selects any of the
+// other formats.
+//
+// For gzip input the header is read right away and exposed through the
+// Reader's Header fields.
+func NewReader(in io.Reader, opts ...Opts) (*Reader, error) {
+	o, err := getOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(in, nil, o)
+}
+
+// NewReaderDict is like NewReader but uses a preset dictionary, which must be
+// the one the data was compressed with. For zlib input the dictionary is only
+// used if the stream header asks for one; for the other formats it is always
+// used.
+func NewReaderDict(in io.Reader, dict []byte, opts ...Opts) (*Reader, error) {
+	o, err := getOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(in, dict, o)
+}
+
+// NewZlibReader creates a Reader for zlib (RFC 1950) data. The Adler-32
+// trailer is verified once the end of the stream is reached.
+func NewZlibReader(in io.Reader) (*Reader, error) {
+	return newReader(in, nil, Opts{Format: Zlib})
+}
+
+func newReader(in io.Reader, dict []byte, opts Opts) (*Reader, error) {
+	z := new(Reader)
+	impl, err := newReaderImpl(&z.Header, in, append([]byte(nil), dict...), opts)
+	if err != nil {
+		return nil, err
+	}
+	z.impl = impl
+	return z, nil
+}
+
+// Read implements io.Reader, reading uncompressed bytes from its underlying
+// Reader. The data is inflated straight into p, at most len(p) bytes per call,
+// so a Reader only ever holds one window of compressed input however large the
+// stream is. Read returns as soon as it has some data and would otherwise have
+// to wait for more input.
+func (z *Reader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.impl.read(p)
+	if err != nil {
+		z.err = err
+	}
+	return n, err
+}
+
+// Multistream controls whether the reader supports multistream files.
+//
+// If enabled (the default), the Reader expects the input to be a sequence of
+// individually gzipped data streams, each with its own header and trailer,
+// ending at EOF. The effect is that the concatenation of a sequence of gzipped
+// files is treated as equivalent to the gzip of the concatenation of the
+// sequence. This is standard behavior for gzip readers.
+//
+// Calling Multistream(false) disables this behavior; the Reader then stops
+// after the first gzip member and returns io.EOF. Input read past the end of
+// that member is not given back to the underlying reader.
+//
+// Only the Gzip format has members; for other formats this has no effect.
+// z.Header holds the header of the member being read.
+func (z *Reader) Multistream(ok bool) {
+	z.impl.setMultistream(ok)
+}
+
+// Close implements io.Closer
+func (z *Reader) Close() error {
+	if z.closed {
+		return errReaderClosed
+	}
+	z.closed = true
+	z.impl.close()
+	if z.err == nil {
+		z.err = errReaderClosed
+	}
+	return nil
+}
+
+// Reset discards the Reader z's state and makes it equivalent to the result
+// of its original state from NewReader or NewReaderDict, but reading from r
+// instead. The format, options and dictionary are kept and Multistream is
+// turned back on. For gzip input the header is read right away, as
+// NewReader does. This permits reusing a Reader rather than allocating a new
+// one, also after Close.
+func (z *Reader) Reset(r io.Reader) error {
+	z.Header = Header{}
+	z.closed = false
+	z.err = z.impl.reset(r)
+	return z.err
+}
+
+// Writer is the gzip/flate writer. It implements io.WriterCloser.
+type Writer struct {
+	Header // written at first call to Write, Flush, or Close
+	impl   writerImpl
+	level  int
+	closed bool
+	err    error
+}
+
+//NewWriter returns a new Writer.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the Writer when done.
+// Writes may be buffered and not flushed until Close.
+//
+// Callers that wish to set the fields in Writer.Header must do so before
+// the first call to Write, Flush, or Close.
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	z, err := NewWriterLevel(w, DEFAULT_LEVEL)
+	return z, err
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead
+// of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression, HuffmanOnly
+// or any integer value between BestSpeed and BestCompression inclusive.
+// The error returned will be nil if the level is valid.
+//
+// There can be at most one options arg. Without one the output is gzip;
+// Opts.Format selects any of the other formats.
+
+func NewWriterLevel(w io.Writer, level int, opts ...Opts) (*Writer, error) {
+	o, err := getOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(w, level, o)
+}
+
+// NewWriterDict is like NewWriterLevel but initializes the new Writer with a
+// preset dictionary. The compressed data can only be read back by a Reader
+// given the same dictionary, see NewReaderDict. Zlib output records the
+// dictionary's Adler-32 checksum in its header.
+//
+// Only the last 32K of dict can be referenced. The Writer keeps its own copy.
+func NewWriterDict(w io.Writer, level int, dict []byte, opts ...Opts) (*Writer, error) {
+	o, err := getOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	z, err := newWriter(w, level, o)
+	if err != nil {
+		return z, err
+	}
+	if len(dict) == 0 {
+		return z, nil
+	}
+	// An unprepared Dictionary carries the copy and its checksum.
+	d := &Dictionary{level: level, raw: append([]byte(nil), dict...)}
+	d.id = adler32.Checksum(d.raw)
+	if err := z.impl.setDict(d); err != nil {
+		z.impl.free()
+		return nil, err
+	}
+	return z, nil
+}
+
+// NewZlibWriter is like NewWriter but produces zlib (RFC 1950) output with an
+// Adler-32 trailer instead of gzip.
+func NewZlibWriter(w io.Writer) (*Writer, error) {
+	return newWriter(w, DEFAULT_LEVEL, Opts{Format: Zlib})
+}
+
+// NewZlibWriterLevel is like NewZlibWriter but specifies the compression level.
+func NewZlibWriterLevel(w io.Writer, level int) (*Writer, error) {
+	return newWriter(w, level, Opts{Format: Zlib})
+}
+
+func newWriter(w io.Writer, level int, opts Opts) (*Writer, error) {
+	if level < 0 || level > 3 {
+		return nil, ErrInvalidLevel
+	}
+	if opts.LevelBuffer != nil && len(opts.LevelBuffer) < LevelBufferSize(level, MemoryMin) {
+		return nil, errLevelBufferSize
+	}
+
+	z := &Writer{
+		Header: Header{OS: headerOSUnknown},
+		level:  level,
+	}
+	impl, err := newWriterImpl(w, level, opts)
+	if err != nil {
+		return nil, err
+	}
+	z.impl = impl
+	// Safety net for Writers that are never closed.
+	runtime.SetFinalizer(z, (*Writer).free)
+	return z, nil
+}
+
+// free releases what the stream holds outside the Go heap, which makes the
+// finalizer unnecessary until reset sets the stream up again.
+func (z *Writer) free() {
+	z.impl.free()
+	runtime.SetFinalizer(z, nil)
+}
+
+// Write implements io.Writer. The data is fed to a single deflate stream that
+// lives until Close, so any number of Write calls produce one gzip member.
+func (z *Writer) Write(in []byte) (int, error) {
+
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errWriterClosed
+	}
+	if len(in) == 0 {
+		return 0, nil
+	}
+
+	if err := z.impl.write(&z.Header, in); err != nil {
+		z.err = err
+		return 0, err
+	}
+	return len(in), nil
+}
+
+// Flush writes any pending compressed data to the underlying writer, ending
+// the current deflate block with an empty stored block (SYNC_FLUSH) so that a
+// reader can decompress everything written so far. It is mainly useful for
+// network protocols; flushing too often hurts compression.
+func (z *Writer) Flush() error {
+	return z.flushMode(false)
+}
+
+// FlushFull is like Flush but uses FULL_FLUSH, which also drops the match
+// history so that the data written afterwards can be decompressed without
+// anything that came before it.
+func (z *Writer) FlushFull() error {
+	return z.flushMode(true)
+}
+
+func (z *Writer) flushMode(full bool) error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+
+	z.err = z.impl.flush(&z.Header, full)
+	return z.err
+}
+
+// Close finishes the stream, writing out any buffered data and the gzip
+// trailer, and releases the memory ISA-L allocated in C, also if an earlier
+// call failed. It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+
+	if z.err == nil {
+		z.err = z.impl.close(&z.Header)
+	}
+	z.free()
+	return z.err
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of its original state from NewWriter, NewWriterLevel, NewWriterDict
+// or NewWriterDictionary, but writing to w instead. The level, format,
+// options and dictionary are kept; Header is cleared. This permits reusing a
+// Writer rather than allocating a new one, also after Close.
+func (z *Writer) Reset(w io.Writer) error {
+	if err := z.reset(w); err != nil {
+		return err
+	}
+	if err := z.impl.applyDict(); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// reset prepares z for a new stream written to w, leaving the dictionary for
+// the caller to apply.
+func (z *Writer) reset(w io.Writer) error {
+	z.Header = Header{OS: headerOSUnknown}
+	if z.closed {
+		runtime.SetFinalizer(z, (*Writer).free)
+	}
+	z.closed = false
+	z.err = z.impl.reset(w)
+	return z.err
+}
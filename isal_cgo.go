@@ -9,456 +9,253 @@ package isal
 import "C"
 
 import (
-	"errors"
-	"fmt"
 	"io"
-	"runtime"
-	"sync"
 	"unsafe"
 )
 
-var errReaderClosed = errors.New("Reader is closed")
-var errCouldNotLoadLib = errors.New("could not load isal library")
-
-const (
-	D_BUF_SIZE      = 640 * 1024
-	C_BUF_SIZE      = 128 * 1024
-	HAS_GZIP_HEADER = 1
-	DEFAULT_LEVEL   = 0
-)
-
-// Variable to check if library is loaded
-var LIB_LOADED = 0
-
-// cPool is a pool of buffers for use in reader.compressionBuffer. Buffers are
-// taken from the pool in NewReaderDict, returned in reader.Close(). Returns a
-// pointer to a slice to avoid the extra allocation of returning the slice as a
-// value.
-var cPool = sync.Pool{
-	New: func() interface{} {
-		buff := make([]byte, D_BUF_SIZE)
-		return &buff
-	},
+// The engines' callers use ISA-L's constants without cgo; each index here is
+// zero, and compiles, only if its constant matches igzip_lib.h or
+// isal_native.h.
+var _ = [1]int{}[codeCompOK-C.COMP_OK]
+var _ = [1]int{}[codeStatelessOverflow-C.STATELESS_OVERFLOW]
+var _ = [1]int{}[codeInvalidState-C.ISAL_INVALID_STATE]
+var _ = [1]int{}[codeInvalidLevel-C.ISAL_INVALID_LEVEL]
+var _ = [1]int{}[codeInvalidLevelBuf-C.ISAL_INVALID_LEVEL_BUF]
+var _ = [1]int{}[codeInvalidFlush-C.INVALID_FLUSH]
+var _ = [1]int{}[codeInvalidParam-C.INVALID_PARAM]
+var _ = [1]int{}[codeInvalidOperation-C.ISAL_INVALID_OPERATION]
+var _ = [1]int{}[codeDecompOK-C.ISAL_DECOMP_OK]
+var _ = [1]int{}[codeEndInput-C.ISAL_END_INPUT]
+var _ = [1]int{}[codeOutOverflow-C.ISAL_OUT_OVERFLOW]
+var _ = [1]int{}[codeNameOverflow-C.ISAL_NAME_OVERFLOW]
+var _ = [1]int{}[codeCommentOverflow-C.ISAL_COMMENT_OVERFLOW]
+var _ = [1]int{}[codeExtraOverflow-C.ISAL_EXTRA_OVERFLOW]
+var _ = [1]int{}[codeNeedDict-C.ISAL_NEED_DICT]
+var _ = [1]int{}[codeInvalidBlock-C.ISAL_INVALID_BLOCK]
+var _ = [1]int{}[codeInvalidSymbol-C.ISAL_INVALID_SYMBOL]
+var _ = [1]int{}[codeInvalidLookback-C.ISAL_INVALID_LOOKBACK]
+var _ = [1]int{}[codeInvalidWrapper-C.ISAL_INVALID_WRAPPER]
+var _ = [1]int{}[codeUnsupportedMethod-C.ISAL_UNSUPPORTED_METHOD]
+var _ = [1]int{}[codeIncorrectChecksum-C.ISAL_INCORRECT_CHECKSUM]
+var _ = [1]int{}[noFlush-C.NO_FLUSH]
+var _ = [1]int{}[syncFlush-C.SYNC_FLUSH]
+var _ = [1]int{}[fullFlush-C.FULL_FLUSH]
+var _ = [1]int{}[featureDict-C.IG_FEATURE_DICT]
+var _ = [1]int{}[featureDictProcess-C.IG_FEATURE_DICT_PROCESS]
+var _ = [1]int{}[featureHuffman-C.IG_FEATURE_HUFFMAN]
+var _ = [1]int{}[featureZlibHeader-C.IG_FEATURE_ZLIB_HEADER]
+
+// isalLevelBufSizes is levelBufSizes as igzip_lib.h defines it, for the
+// tests to compare.
+var isalLevelBufSizes = [4][6]int{
+	{C.ISAL_DEF_LVL0_DEFAULT, C.ISAL_DEF_LVL0_MIN, C.ISAL_DEF_LVL0_SMALL,
+		C.ISAL_DEF_LVL0_MEDIUM, C.ISAL_DEF_LVL0_LARGE, C.ISAL_DEF_LVL0_EXTRA_LARGE},
+	{C.ISAL_DEF_LVL1_DEFAULT, C.ISAL_DEF_LVL1_MIN, C.ISAL_DEF_LVL1_SMALL,
+		C.ISAL_DEF_LVL1_MEDIUM, C.ISAL_DEF_LVL1_LARGE, C.ISAL_DEF_LVL1_EXTRA_LARGE},
+	{C.ISAL_DEF_LVL2_DEFAULT, C.ISAL_DEF_LVL2_MIN, C.ISAL_DEF_LVL2_SMALL,
+		C.ISAL_DEF_LVL2_MEDIUM, C.ISAL_DEF_LVL2_LARGE, C.ISAL_DEF_LVL2_EXTRA_LARGE},
+	{C.ISAL_DEF_LVL3_DEFAULT, C.ISAL_DEF_LVL3_MIN, C.ISAL_DEF_LVL3_SMALL,
+		C.ISAL_DEF_LVL3_MEDIUM, C.ISAL_DEF_LVL3_LARGE, C.ISAL_DEF_LVL3_EXTRA_LARGE},
 }
 
-// dPool is a pool of buffers for use in reader.decompressionBuffer. Buffers are
-// taken from the pool in NewReaderDict, returned in reader.Close(). Returns a
-// pointer to a slice to avoid the extra allocation of returning the slice as a
-// value.
-var dPool = sync.Pool{
-	New: func() interface{} {
-		buff := make([]byte, 12*D_BUF_SIZE)
-		return &buff
-	},
+// deflateFlag returns the isal_zstream.gzip_flag for f. The gzip and zlib
+// headers are written by the Writer itself, so ISA-L only adds the trailer.
+func (f Format) deflateFlag() C.int {
+	switch f {
+	case Gzip, GzipNoHeader:
+		return C.IGZIP_GZIP_NO_HDR
+	case Zlib, ZlibNoHeader:
+		return C.IGZIP_ZLIB_NO_HDR
+	default:
+		return C.IGZIP_DEFLATE
+	}
 }
 
-func resize(in []byte, newSize int) []byte {
-	if in == nil {
-		return make([]byte, newSize)
-	}
-	if newSize <= cap(in) {
-		return in[:newSize]
+// inflateFlag returns the inflate_state.crc_flag for f. The headerless
+// variants still have a trailer, which ISA-L verifies.
+func (f Format) inflateFlag() C.int {
+	switch f {
+	case Gzip:
+		return C.ISAL_GZIP
+	case GzipNoHeader:
+		return C.ISAL_GZIP_NO_HDR_VER
+	case Zlib:
+		return C.ISAL_ZLIB
+	case ZlibNoHeader:
+		return C.ISAL_ZLIB_NO_HDR_VER
+	default:
+		return C.ISAL_DEFLATE
 	}
-	toAdd := newSize - len(in)
-	return append(in, make([]byte, toAdd)...)
 }
 
 type zstream [unsafe.Sizeof(C.isal_zstream{})]C.char
 type inf_state [unsafe.Sizeof(C.inflate_state{})]C.char
-type isalgzheader [unsafe.Sizeof(C.isal_gzip_header{})]C.char
-
-// Reader is a gzip/zlib/flate reader. It implements io.ReadCloser.  Calling
-// Close is optional, though strongly recommended.  NewReader() also installs a
-// GC finalizer that closes the Reader, in case the application forgets to call
-// Close.
-type Reader struct {
-	underlyingReader     io.Reader
-	zs                   inf_state
-	inEOF                bool // true if in reaches io.EOF
-	firstError           error
-	gzHeader             isalgzheader
-	hasGzHeader          bool // true if gzHeader was successfully set.
-	compressionBuffer    []byte
-	decompressionBuffer  []byte
-	tempDecompressBuffer []byte
-	decompOff            int
-	compressionLeft      int
-	remaining            int
-	previous             int
-	err                  error
-}
-
-// NewReader creates a gzip/flate reader. There can be at most one options arg.
-func NewReader(in io.Reader) (*Reader, error) {
-
-	var err error
-	var ready bool
 
-	// load the isal library if not loaded by Ready()
-	if LIB_LOADED == 0 {
-		ready = Ready()
-		if !ready {
-			return nil, errCouldNotLoadLib
-		}
-	}
-	compressionBufferP := cPool.Get().(*[]byte)
-	z := &Reader{
-		underlyingReader:  in,
-		compressionBuffer: *compressionBufferP,
-		firstError:        err,
-		compressionLeft:   0,
-		previous:          0,
-		remaining:         0,
-	}
-	if ec := C.ig_isal_inflate_init(&z.zs[0]); ec != 0 {
-		return nil, isalReturnCodeToError(ec)
-	}
+// isalgzheader is made of words rather than chars so that it is aligned for
+// the isal_gzip_header that gzipHeader reads from it.
+type isalgzheader [(unsafe.Sizeof(C.isal_gzip_header{}) + 7) / 8]uint64
 
-	return z, nil
+func (h *isalgzheader) ptr() *C.char {
+	return (*C.char)(unsafe.Pointer(&h[0]))
 }
 
-// Writer is the gzip/flate writer. It implements io.WriterCloser.
-type Writer struct {
-	out      io.Writer
-	zs       zstream // underlying zlib implementation.
-	gzHeader isalgzheader
-	outBuf   []byte
-	level    int
-	err      error
+func newISALWriter(w io.Writer, level int, opts Opts) (writerImpl, error) {
+	return newDeflateWriter(new(nativeDeflater), w, level, opts)
 }
 
-//NewWriter returns a new Writer.
-// Writes to the returned writer are compressed and written to w.
-//
-// It is the caller's responsibility to call Close on the Writer when done.
-// Writes may be buffered and not flushed until Close.
-//
-// Callers that wish to set the fields in Writer.Header must do so before
-// the first call to Write, Flush, or Close.
-
-func NewWriter(w io.Writer) (*Writer, error) {
-	z, err := NewWriterLevel(w, DEFAULT_LEVEL)
-	return z, err
+func newISALReader(hdr *Header, r io.Reader, dict []byte, opts Opts) (readerImpl, error) {
+	return newInflateReader(new(nativeInflater), hdr, r, dict, opts)
 }
 
-// NewWriterLevel is like NewWriter but specifies the compression level instead
-// of assuming DefaultCompression.
-//
-// The compression level can be DefaultCompression, NoCompression, HuffmanOnly
-// or any integer value between BestSpeed and BestCompression inclusive.
-// The error returned will be nil if the level is valid.
-
-func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
-	var ready bool
-	if LIB_LOADED == 0 {
-		ready = Ready()
-		if !ready {
-			return nil, errCouldNotLoadLib
-		}
-	}
-
-	z := &Writer{
-		out:    w,
-		outBuf: make([]byte, C_BUF_SIZE),
-		level:  level,
-	}
-
-	if level < 0 || level > 3 {
-		return z, errors.New("isal.gzip: invalid compression level")
-	}
-
-	ec := C.ig_isal_deflate_init(&z.zs[0], C.int(level))
-
-	if HAS_GZIP_HEADER == 1 {
-		C.ig_isal_gzip_header_init(&z.gzHeader[0])
-	}
-
-	if ec != 0 {
-		return nil, isalReturnCodeToError(ec)
-	}
-	return z, nil
+// nativeDeflater is the deflater that calls into ISA-L.
+type nativeDeflater struct {
+	zs       zstream // first, so that it is aligned
+	levelBuf []byte
+	huff     *HuffmanTables
+	// Arguments of the cgo calls; as locals they would escape to the heap on
+	// every call.
+	availIn, availOut, state C.int
 }
 
-//Adds a Ready() API that will check if the ISAL library is loadable and will load it
-//If NewWriter/NewReader or NewWriterLevel is called before Ready, initialize the dynamic loads using Ready()
-
-func Ready() bool {
-	if LIB_LOADED == 1 {
-		return true
-	}
-	// load the isal library and the symbols
-	ret := C.isal_dload_functions()
-	if ret != 0 {
-		fmt.Printf("isal: library could not be dynamically loaded %d", 1)
-		return false
-	} else {
-		LIB_LOADED = 1
-	}
-
-	return true
+func (d *nativeDeflater) require(feature int) error {
+	return require(C.int(feature))
 }
 
-// Write implements io.Writer.
-func (z *Writer) Write(in []byte) (int, error) {
-
-	if len(in) == 0 {
-		return 0, nil
-	}
-	var (
-		outLen     = C.int(len(z.outBuf))
-		inConsumed C.int
-		length     int
-	)
-
-	if len(z.outBuf) > len(in) {
-
-		ret := C.ig_isal_deflate_stateless(&z.zs[0], (*C.uint8_t)(unsafe.Pointer(&in[0])), C.int(len(in)), (*C.uint8_t)(unsafe.Pointer(&z.outBuf[0])), &outLen, &inConsumed, HAS_GZIP_HEADER, &z.gzHeader[0])
-
-		if ret != 0 {
-			return 0, isalReturnCodeToError(ret)
-		}
-
-		nOut := len(z.outBuf) - int(outLen)
-		if err := z.flush(z.outBuf[:nOut]); err != nil {
-			return 0, err
-		}
-		return len(in), nil
-
-	} else {
-		ec := C.ig_isal_deflate_init(&z.zs[0], C.int(z.level))
-		if ec != 0 {
-			return 0, isalReturnCodeToError(ec)
-		}
-
-		if HAS_GZIP_HEADER == 1 {
-			C.ig_isal_gzip_header_init(&z.gzHeader[0])
-		}
-
-		outLen = C.int(len(z.outBuf))
-
-		infile_size := C.int(len(in))
-
-		end_of_stream := C.int(0)
-		state := C.int(0)
-		for {
-
-			avail_in := C.int(C_BUF_SIZE)
-			if infile_size < avail_in {
-				end_of_stream = 1
-				avail_in = infile_size
-			}
-
-			for {
-				avail_out := C.int(C_BUF_SIZE)
-
-				C.ig_isal_deflate(&z.zs[0], (*C.uint8_t)(unsafe.Pointer(&in[0])), (*C.uint8_t)(unsafe.Pointer(&z.outBuf[0])), &avail_out, &end_of_stream, &state, &avail_in, HAS_GZIP_HEADER, &z.gzHeader[0])
-
-				nOut := C_BUF_SIZE - avail_out
-
-				if nOut != 0 {
-					length = length + int(nOut)
-					if err := z.flush(z.outBuf[:nOut]); err != nil {
-						return 0, err
-					}
-
-				}
-
-				if avail_out != 0 {
-					break
-				}
-			}
-
-			infile_size = infile_size - C_BUF_SIZE
-			if state != 0 {
-				break
-			}
-
-		}
-
-		return len(in), nil
+func (d *nativeDeflater) init(level, windowBits, levelBufSize int, levelBuf []byte) int {
+	goBuf := C.int(0)
+	if levelBuf != nil {
+		goBuf = 1
 	}
-
+	d.levelBuf, d.huff = levelBuf, nil
+	return int(C.ig_isal_deflate_init(&d.zs[0], C.int(level), C.int(windowBits), C.int(levelBufSize), goBuf))
 }
 
-// Read implements io.Reader, reading uncompressed bytes from its underlying Reader.
-func (z *Reader) Read(p []byte) (n int, err error) {
-
-	if z.err != nil {
-		return 0, z.err
+func (d *nativeDeflater) setHufftables(mode HuffmanMode, t *HuffmanTables) int {
+	huffType := mode.hufftableType()
+	if t != nil {
+		huffType = C.IGZIP_HUFFTABLE_CUSTOM
 	}
-	if z.remaining > 0 {
-
-		n = copy(p, z.tempDecompressBuffer[z.decompOff:z.decompOff+z.remaining])
-		z.decompOff += n
-		z.remaining -= n
-		if z.remaining <= 0 {
-			return n, io.EOF
-		} else {
-			return n, nil
-		}
-	}
-	avail_out := C.int(0)
-
-	if len(p) < 1024 {
-		if len(z.compressionBuffer) == 0 {
-			compressionBufferP := cPool.Get().(*[]byte)
-			z.compressionBuffer = *compressionBufferP
-		}
-		decompressionBufferP := dPool.Get().(*[]byte)
-		tempDecompressBufferP := dPool.Get().(*[]byte)
-		z.decompressionBuffer = *decompressionBufferP
-		z.tempDecompressBuffer = *tempDecompressBufferP
-		avail_out = C.int(len(z.decompressionBuffer))
-	} else {
-		runtime.GC()
-		avail_out = C.int(len(p))
-
-	}
-	inbytes, err := z.underlyingReader.Read(z.compressionBuffer)
-
-	state := C.int(0)
-	totalOut := C.int(0)
-	avail_in := C.int(inbytes)
-	ret := C.int(0)
-	prevOut := 0
-
-	for {
-		avail_in = C.int(inbytes)
-		if len(p) > 1024 {
-			ret = C.ig_isal_inflate(&z.zs[0], (*C.uint8_t)(unsafe.Pointer(&z.compressionBuffer[0])), C.int(inbytes),
-				(*C.uint8_t)(unsafe.Pointer(&p[0])), &avail_out, &totalOut, &state, &avail_in, HAS_GZIP_HEADER, &z.gzHeader[0])
-		} else {
-			ret = C.ig_isal_inflate_buffered(&z.zs[0], (*C.uint8_t)(unsafe.Pointer(&z.compressionBuffer[0])), C.int(inbytes),
-				(*C.uint8_t)(unsafe.Pointer(&z.decompressionBuffer[0])), &avail_out, &totalOut, &state, &avail_in, HAS_GZIP_HEADER, &z.gzHeader[0])
-		}
-		if ret != 0 {
-			z.err = isalReturnCodeToError(ret)
-			return 0, z.err
-		}
-
-		if len(p) < 1024 {
-			n = copy(z.tempDecompressBuffer[prevOut:], z.decompressionBuffer[:int(totalOut)-prevOut])
-			prevOut = prevOut + n
-			avail_out = C.int(len(z.decompressionBuffer))
-		}
-		if err == io.EOF {
-
-			break
-		}
-
-		if len(p) < 1024 {
-			if len(z.tempDecompressBuffer) < (len(z.decompressionBuffer) + int(totalOut)) {
-				z.tempDecompressBuffer = append(z.tempDecompressBuffer, make([]byte, 12*D_BUF_SIZE)...)
-			}
-		}
-
-		inbytes, err = z.underlyingReader.Read(z.compressionBuffer)
-		if inbytes == 0 {
-			break
-
-		}
+	d.huff = t
+	return int(C.ig_isal_deflate_set_hufftables(&d.zs[0], t.ptr(), huffType))
+}
 
+func (d *nativeDeflater) setDict(dict *Dictionary) int {
+	if dict.prepared != nil {
+		return int(C.ig_isal_deflate_reset_dict(&d.zs[0], &dict.prepared[0], bufPtr(d.levelBuf, 0)))
 	}
+	return int(C.ig_isal_deflate_set_dict(&d.zs[0], bufPtr(dict.raw, 0), C.int(len(dict.raw)), bufPtr(d.levelBuf, 0)))
+}
 
-	if len(p) < 1024 {
-		z.decompOff = copy(p, z.tempDecompressBuffer[:int(totalOut)])
-		if z.decompOff < int(totalOut) {
-			z.remaining = int(totalOut) - z.decompOff
-		}
-		if z.decompOff < int(totalOut) {
-			return z.decompOff, nil
-		} else {
-			return z.decompOff, io.EOF
-		}
-	} else {
-		return int(totalOut), err
+func (d *nativeDeflater) deflate(in, out []byte, flush int, end bool, format Format) (int, int, bool, int) {
+	endOfStream := C.int(0)
+	if end {
+		endOfStream = 1
 	}
+	d.availIn, d.availOut, d.state = C.int(len(in)), C.int(len(out)), 0
+	ret := C.ig_isal_deflate(&d.zs[0], bufPtr(in, 0), &d.availIn, bufPtr(out, 0), &d.availOut,
+		C.int(flush), endOfStream, &d.state, format.deflateFlag(), d.huff.ptr(), bufPtr(d.levelBuf, 0))
+	return len(in) - int(d.availIn), len(out) - int(d.availOut), d.state != 0, int(ret)
+}
 
+func (d *nativeDeflater) deflateStateless(in, out []byte, format Format) (int, int) {
+	d.availOut = C.int(len(out))
+	ret := C.ig_isal_deflate_stateless(&d.zs[0], bufPtr(in, 0), C.int(len(in)), bufPtr(out, 0), &d.availOut,
+		format.statelessFlag(), bufPtr(d.levelBuf, 0))
+	return len(out) - int(d.availOut), int(ret)
 }
 
-// Close implements io.Closer
-func (z *Reader) Close() error {
+func (d *nativeDeflater) writeGzipHeader(out []byte, mtime uint32, os byte, extra, name, comment []byte) (int, int) {
+	// ISA-L only writes FEXTRA for a non-NULL pointer, which SliceData gives
+	// for an empty but non-nil extra too.
+	d.availOut = C.int(len(out))
+	ret := C.ig_isal_write_gzip_header(&d.zs[0], bufPtr(out, 0), &d.availOut, C.uint32_t(mtime), C.int(os),
+		(*C.uint8_t)(unsafe.SliceData(extra)), C.int(len(extra)),
+		(*C.char)(unsafe.Pointer(bufPtr(name, 0))), (*C.char)(unsafe.Pointer(bufPtr(comment, 0))))
+	return len(out) - int(d.availOut), int(ret)
+}
 
-	if z.firstError != nil {
-		return z.firstError
+func (d *nativeDeflater) writeZlibHeader(out []byte, info, level int, hasDict bool, dictID uint32) (int, int) {
+	dictFlag := C.int(0)
+	if hasDict {
+		dictFlag = 1
 	}
+	d.availOut = C.int(len(out))
+	ret := C.ig_isal_write_zlib_header(&d.zs[0], bufPtr(out, 0), &d.availOut, C.int(info), C.int(level), dictFlag, C.uint32_t(dictID))
+	return len(out) - int(d.availOut), int(ret)
+}
 
-	cb := z.compressionBuffer
-	db := z.decompressionBuffer
-	// Ensure that we won't resuse buffer
-	z.firstError = errReaderClosed
-	z.compressionBuffer = nil
-	z.decompressionBuffer = nil
-
-	cPool.Put(&cb)
-	dPool.Put(&db)
-
-	return nil
-
+func (d *nativeDeflater) reset() {
+	C.ig_isal_deflate_reset(&d.zs[0])
 }
 
-// Flush writes the data to the output.
-func (z *Writer) flush(data []byte) error {
-	n, err := z.out.Write(data)
-	if err != nil {
-		return err
-	}
-	if n < len(data) { // shouldn't happen in practice
-		return fmt.Errorf("zlib: n=%d, outLen=%d", n, len(data))
-	}
-	return nil
+func (d *nativeDeflater) end() {
+	C.ig_isal_deflate_end(&d.zs[0])
 }
 
-// Close implements io.Closer
-func (z *Writer) Close() error {
+// nativeInflater is the inflater that calls into ISA-L.
+type nativeInflater struct {
+	zs       inf_state // first, so that it is aligned
+	gzHeader isalgzheader
+	// Arguments of the cgo calls, see nativeDeflater.
+	availIn, availOut, state C.int
+}
 
-	if z.err != nil {
-		return z.err
-	}
+func (f *nativeInflater) require(feature int) error {
+	return require(C.int(feature))
+}
 
-	return nil
+func (f *nativeInflater) init() int {
+	return int(C.ig_isal_inflate_init(&f.zs[0]))
 }
 
-// Reset discards the Writer z's state and makes it equivalent to the
-// result of its original state from NewWriter or NewWriterLevel, but
-// writing to w instead. This permits reusing a Writer rather than
-// allocating a new one.
-func (z *Writer) Reset(w io.Writer) error {
+func (f *nativeInflater) reset() {
+	C.ig_isal_inflate_reset(&f.zs[0])
+}
 
-	z = &Writer{
-		out:   w,
-		level: z.level,
-	}
+func (f *nativeInflater) setDict(dict []byte) int {
+	return int(C.ig_isal_inflate_set_dict(&f.zs[0], bufPtr(dict, 0), C.int(len(dict))))
+}
 
-	C.ig_isal_deflate_reset(&z.zs[0])
-	return z.err
+func (f *nativeInflater) inflate(in, out []byte, format Format, windowBits int) (int, int, bool, int) {
+	f.availIn, f.availOut, f.state = C.int(len(in)), C.int(len(out)), 0
+	ret := C.ig_isal_inflate(&f.zs[0], bufPtr(in, 0), &f.availIn, bufPtr(out, 0), &f.availOut,
+		format.inflateFlag(), C.int(windowBits), &f.state)
+	return len(in) - int(f.availIn), len(out) - int(f.availOut), f.state != 0, int(ret)
+}
 
+func (f *nativeInflater) inflateStateless(in, out []byte, format Format) (int, int, int) {
+	f.availIn, f.availOut = C.int(len(in)), C.int(len(out))
+	ret := C.ig_isal_inflate_stateless(&f.zs[0], bufPtr(in, 0), &f.availIn, bufPtr(out, 0), &f.availOut,
+		format.inflateFlag())
+	return len(in) - int(f.availIn), len(out) - int(f.availOut), int(ret)
 }
 
-func (z *Reader) Reset(r io.Reader) error {
+func (f *nativeInflater) initGzipHeader() {
+	C.ig_isal_gzip_header_init(f.gzHeader.ptr())
+}
 
-	z.underlyingReader = r
-	C.ig_isal_inflate_init(&z.zs[0])
-	return z.err
+func (f *nativeInflater) readGzipHeader(in, extra, name, comment []byte) (int, int) {
+	f.availIn = C.int(len(in))
+	ret := C.ig_isal_read_gzip_header(&f.zs[0], bufPtr(in, 0), &f.availIn, f.gzHeader.ptr(),
+		bufPtr(extra, 0), C.int(len(extra)),
+		(*C.char)(unsafe.Pointer(bufPtr(name, 0))), C.int(len(name)),
+		(*C.char)(unsafe.Pointer(bufPtr(comment, 0))), C.int(len(comment)))
+	return len(in) - int(f.availIn), int(ret)
+}
 
+func (f *nativeInflater) gzipHeader() (uint32, byte, int) {
+	h := (*C.isal_gzip_header)(unsafe.Pointer(f.gzHeader.ptr()))
+	// extra_len is 0 without the field too; flags keeps the FLG byte.
+	if h.flags&gzipFlagExtra == 0 {
+		return uint32(h.time), byte(h.os), -1
+	}
+	return uint32(h.time), byte(h.os), int(h.extra_len)
 }
 
-func isalReturnCodeToError(r C.int) error {
-	if r == 0 {
+// bufPtr returns a C pointer to b[off:], or nil if that is empty.
+func bufPtr(b []byte, off int) *C.uint8_t {
+	if off >= len(b) {
 		return nil
 	}
-	if r == C.ISAL_END_INPUT {
-		return fmt.Errorf("isal: End of input reached%d", r)
-	}
-	if r == C.ISAL_INVALID_LEVEL {
-		return fmt.Errorf("isal: invalid level passed %d", r)
-	}
-	if r == C.ISAL_INVALID_LEVEL_BUF {
-		return fmt.Errorf("isal: isal_invalid_level_buf %d", r)
-	}
-	if r == C.STATELESS_OVERFLOW {
-		return fmt.Errorf("isal: stateless overflow %d", r)
-	}
-
-	return fmt.Errorf("isal: unknown error %d", r)
+	return (*C.uint8_t)(unsafe.Pointer(&b[off]))
 }
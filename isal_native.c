@@ -1,3 +1,4 @@
+#define _GNU_SOURCE
 #include <dlfcn.h>
 #include "igzip_lib.h"
 #include "isal_native.h"
@@ -18,62 +19,146 @@ static I_isal_inflate_stateless_t I_isal_inflate_stateless = NULL;
 static I_isal_gzip_header_init_t I_isal_gzip_header_init = NULL;
 static I_isal_write_gzip_header_t I_isal_write_gzip_header = NULL;
 static I_isal_read_gzip_header_t I_isal_read_gzip_header = NULL;
+static I_isal_write_zlib_header_t I_isal_write_zlib_header = NULL;
+static I_isal_deflate_set_dict_t I_isal_deflate_set_dict = NULL;
+static I_isal_inflate_set_dict_t I_isal_inflate_set_dict = NULL;
+static I_isal_deflate_process_dict_t I_isal_deflate_process_dict = NULL;
+static I_isal_deflate_reset_dict_t I_isal_deflate_reset_dict = NULL;
+static I_isal_update_histogram_t I_isal_update_histogram = NULL;
+static I_isal_create_hufftables_t I_isal_create_hufftables = NULL;
+static I_isal_create_hufftables_t I_isal_create_hufftables_subset = NULL;
+static I_isal_deflate_set_hufftables_t I_isal_deflate_set_hufftables = NULL;
 
 
 
 
-int isal_dload_symbols(void *handle, symbol_info_t * symbols, int num_symbols)
+#ifdef ISAL_STATIC
+
+// With ISA-L linked in statically there is nothing to load: the function
+// pointers are set to the linked functions and every feature is present.
+int isal_dload_functions(const char *path, const char **missing, char *err, int err_len, int *features, char *file, int file_len)
+{
+	I_isal_inflate_init = (I_isal_inflate_init_t)isal_inflate_init;
+	I_isal_deflate_init = (I_isal_deflate_init_t)isal_deflate_init;
+	I_isal_deflate_stateless = isal_deflate_stateless;
+	I_isal_inflate_stateless = isal_inflate_stateless;
+	I_isal_deflate = isal_deflate;
+	I_isal_inflate = isal_inflate;
+	I_isal_gzip_header_init = (I_isal_gzip_header_init_t)isal_gzip_header_init;
+	I_isal_write_gzip_header = (I_isal_write_gzip_header_t)isal_write_gzip_header;
+	I_isal_read_gzip_header = isal_read_gzip_header;
+	I_isal_write_zlib_header = (I_isal_write_zlib_header_t)isal_write_zlib_header;
+	I_isal_deflate_set_dict = isal_deflate_set_dict;
+	I_isal_inflate_set_dict = isal_inflate_set_dict;
+	I_isal_deflate_process_dict = isal_deflate_process_dict;
+	I_isal_deflate_reset_dict = isal_deflate_reset_dict;
+	I_isal_update_histogram = isal_update_histogram;
+	I_isal_create_hufftables = isal_create_hufftables;
+	I_isal_create_hufftables_subset = isal_create_hufftables_subset;
+	I_isal_deflate_set_hufftables = isal_deflate_set_hufftables;
+
+	*missing = NULL;
+	*features = IG_FEATURE_ALL;
+	file[0] = 0;
+	return 0;
+}
+
+#else
+
+// isal_dload_symbols resolves all symbols before storing any of them, so a
+// library that lacks a required one leaves the function pointers as they
+// were. On failure *missing names the symbol and err holds the dlerror()
+// text. *features gets the IG_FEATURE_* bits whose symbols were all found;
+// the pointers of the other optional symbols are set to NULL.
+int isal_dload_symbols(void *handle, symbol_info_t * symbols, int num_symbols, const char **missing, char *err, int err_len, int *features)
 {
+	void *found[num_symbols];
+	int have = IG_FEATURE_ALL;
+
 	if (handle == NULL || symbols == NULL)
 		return -1;
 
+	dlerror();
 	for (int i = 0; i < num_symbols; i++) {
-		*symbols[i].func = dlsym(handle, symbols[i].name);
+		found[i] = dlsym(handle, symbols[i].name);
 		char *error = dlerror();
-		if (error != NULL) {
-			printf("failed to load symbols %s \n", symbols[i].name);
-			return -1;
+		if (error == NULL)
+			continue;
+		if (symbols[i].feature != 0) {
+			have &= ~symbols[i].feature;
+			continue;
 		}
+		*missing = symbols[i].name;
+		snprintf(err, err_len, "%s", error);
+		return -1;
+	}
+	for (int i = 0; i < num_symbols; i++) {
+		if (symbols[i].func == NULL)
+			continue;
+		*symbols[i].func = (have & symbols[i].feature) == symbols[i].feature ? found[i] : NULL;
 	}
+	*features = have;
 	return 0;
 }
 
 
-int isal_dload_functions()
+// isal_dload_functions opens the ISA-L library at path and resolves the
+// functions used by the package. On failure err holds the dlerror() text and
+// *missing the required symbol that could not be found, or NULL if dlopen
+// failed. On success file gets the path of the file that was loaded.
+int isal_dload_functions(const char *path, const char **missing, char *err, int err_len, int *features, char *file, int file_len)
 {
 	void *isal_handle;
 	int status = -1;
+	Dl_info info;
 
-	char *isal_lib_env = getenv("ISAL_LIB_PATH");
-
-	isal_handle = dlopen(isal_lib_env ? isal_lib_env : ISAL_LIB, RTLD_LAZY);
-	
+	*missing = NULL;
+	isal_handle = dlopen(path, RTLD_LAZY);
 	if (!isal_handle) {
-		printf("Failed to load isal library \n");
+		snprintf(err, err_len, "%s", dlerror());
 		return -1;
 	}
 
 
 	symbol_info_t isal_symbols[] = {
-		{ "isal_inflate_init", (void **)&I_isal_inflate_init },
-		{ "isal_deflate_init", (void **)&I_isal_deflate_init },
-		{ "isal_deflate_stateless", (void **)&I_isal_deflate_stateless },
-		{ "isal_inflate_stateless", (void **)&I_isal_inflate_stateless },
-		{ "isal_deflate", (void **)&I_isal_deflate },
-		{ "isal_inflate", (void **)&I_isal_inflate },
-		{ "isal_gzip_header_init", (void **)&I_isal_gzip_header_init },
-		{ "isal_write_gzip_header", (void **)&I_isal_write_gzip_header },
-		{ "isal_read_gzip_header", (void **)&I_isal_read_gzip_header},
+		{ "isal_inflate_init", (void **)&I_isal_inflate_init, 0 },
+		{ "isal_deflate_init", (void **)&I_isal_deflate_init, 0 },
+		{ "isal_deflate_stateless", (void **)&I_isal_deflate_stateless, 0 },
+		{ "isal_inflate_stateless", (void **)&I_isal_inflate_stateless, 0 },
+		{ "isal_deflate", (void **)&I_isal_deflate, 0 },
+		{ "isal_inflate", (void **)&I_isal_inflate, 0 },
+		{ "isal_gzip_header_init", (void **)&I_isal_gzip_header_init, 0 },
+		{ "isal_write_gzip_header", (void **)&I_isal_write_gzip_header, 0 },
+		{ "isal_read_gzip_header", (void **)&I_isal_read_gzip_header, 0 },
+		{ "isal_write_zlib_header", (void **)&I_isal_write_zlib_header, IG_FEATURE_ZLIB_HEADER },
+		{ "isal_deflate_set_dict", (void **)&I_isal_deflate_set_dict, IG_FEATURE_DICT },
+		{ "isal_inflate_set_dict", (void **)&I_isal_inflate_set_dict, IG_FEATURE_DICT },
+		{ "isal_deflate_process_dict", (void **)&I_isal_deflate_process_dict, IG_FEATURE_DICT_PROCESS },
+		{ "isal_deflate_reset_dict", (void **)&I_isal_deflate_reset_dict, IG_FEATURE_DICT_PROCESS },
+		{ "isal_update_histogram", (void **)&I_isal_update_histogram, IG_FEATURE_HUFFMAN },
+		{ "isal_create_hufftables", (void **)&I_isal_create_hufftables, IG_FEATURE_HUFFMAN },
+		{ "isal_create_hufftables_subset", (void **)&I_isal_create_hufftables_subset, IG_FEATURE_HUFFMAN },
+		{ "isal_deflate_set_hufftables", (void **)&I_isal_deflate_set_hufftables, IG_FEATURE_HUFFMAN },
+		{ "crc32_gzip_refl", NULL, IG_FEATURE_CRC },
+		{ "ec_init_tables", NULL, IG_FEATURE_EC },
+		{ "ec_encode_data", NULL, IG_FEATURE_EC },
+		{ "gf_gen_rs_matrix", NULL, IG_FEATURE_EC },
 	};
 
-	status = isal_dload_symbols(isal_handle, isal_symbols, sizeof(isal_symbols) / sizeof(isal_symbols[0]));
+	status = isal_dload_symbols(isal_handle, isal_symbols, sizeof(isal_symbols) / sizeof(isal_symbols[0]), missing, err, err_len, features);
 	if (status != 0) {
+		dlclose(isal_handle);
 		return status;
 	}
 
+	file[0] = 0;
+	if (dladdr((void *)I_isal_inflate, &info) && info.dli_fname != NULL)
+		snprintf(file, file_len, "%s", info.dli_fname);
+
 	return 0;
 }
 
+#endif /* ISAL_STATIC */
 
 
 int ig_isal_gzip_header_init(char* h) {
@@ -83,32 +168,22 @@ int ig_isal_gzip_header_init(char* h) {
 }
 
 
-int ig_isal_deflate_init(char *stream, int level) {
+int ig_isal_deflate_init(char *stream, int level, int hist_bits, int level_buf_size, int go_level_buf) {
 
 	isal_zstream* zs = (isal_zstream*)stream;
 	memset(zs, 0, sizeof(*zs));	
 	I_isal_deflate_init(zs);
 
 	zs->level = level;
+	zs->hist_bits = hist_bits;
+	zs->level_buf_size = level_buf_size;
 
-	if( level == 0 ){
-		zs->level_buf = (uint8_t*)malloc(ISAL_DEF_LVL0_DEFAULT);
-		zs->level_buf_size = ISAL_DEF_LVL0_DEFAULT;
-	}
-	else if( level == 1) {
-		zs->level_buf = (uint8_t*)malloc(ISAL_DEF_LVL1_DEFAULT);
-		zs->level_buf_size = ISAL_DEF_LVL1_DEFAULT;
-	}
-	else if( level == 2) {
-		zs->level_buf = (uint8_t*)malloc(ISAL_DEF_LVL2_DEFAULT);
-		zs->level_buf_size = ISAL_DEF_LVL2_DEFAULT;
-	}
-	else if( level == 3) {
-		zs->level_buf = (uint8_t*)malloc(ISAL_DEF_LVL3_DEFAULT);
-		zs->level_buf_size = ISAL_DEF_LVL3_DEFAULT;
+	// A level buffer owned by Go is passed in with every call instead.
+	if (!go_level_buf && level_buf_size > 0) {
+		zs->level_buf = (uint8_t*)malloc(level_buf_size);
+		if (zs->level_buf == NULL) return ISAL_INVALID_LEVEL_BUF;
 	}
 
-
 	zs->next_in = NULL;
 	zs->avail_in = 0;
 	zs->end_of_stream = 0;
@@ -128,8 +203,22 @@ void ig_isal_inflate_reset(char *stream) {
 void ig_isal_deflate_reset(char *stream) {
 
 	isal_zstream* zs = (isal_zstream*)stream;
+	uint32_t level = zs->level;
+	uint8_t* level_buf = zs->level_buf;
+	uint32_t level_buf_size = zs->level_buf_size;
+	struct isal_hufftables* hufftables = zs->hufftables;
+	uint16_t hist_bits = zs->hist_bits;
+
 	memset(zs, 0, sizeof(*zs));
 	I_isal_deflate_init(zs);
+
+	// Keep the level, its buffer, the Huffman code and the window size so
+	// that the stream can be reused.
+	zs->level = level;
+	zs->level_buf = level_buf;
+	zs->level_buf_size = level_buf_size;
+	zs->hufftables = hufftables;
+	zs->hist_bits = hist_bits;
 }
 
 
@@ -137,78 +226,174 @@ int ig_isal_deflate_end(char *stream) {
 
         isal_zstream* zs = (isal_zstream*)stream;
         free(zs->level_buf);
+        zs->level_buf = NULL;
         return 0;
 }
 
 
 
-int ig_isal_gzip_set_header(char* stream, char* h)
+int ig_isal_write_gzip_header(char* stream, uint8_t* out, int* avail_out, uint32_t time, int os,
+		uint8_t* extra, int extra_len, char* name, char* comment)
 {
-	isal_gzip_header* gh = (isal_gzip_header*)h;
 	isal_zstream* zs = (isal_zstream*)stream;
-	I_isal_write_gzip_header(zs, gh);
+	isal_gzip_header gh;
+
+	I_isal_gzip_header_init(&gh);
+	gh.time = time;
+	gh.os = os;
+	gh.extra = extra;
+	gh.extra_buf_len = extra_len;
+	gh.extra_len = extra_len;
+	gh.name = name;
+	gh.name_buf_len = name ? strlen(name) + 1 : 0;
+	gh.comment = comment;
+	gh.comment_buf_len = comment ? strlen(comment) + 1 : 0;
+
+	zs->next_out = out;
+	zs->avail_out = *avail_out;
+
+	int ret = I_isal_write_gzip_header(zs, &gh);
+
+	*avail_out = zs->avail_out;
+	return ret;
 }
 
 
-int ig_isal_deflate_stateless(char* stream,uint8_t* in, int in_bytes, uint8_t* out, int* out_bytes, int* consumed_inputi, int isheader, char* header) {
+int ig_isal_read_gzip_header(char* stream, uint8_t* in, int* avail_in, char* h,
+		uint8_t* extra, int extra_len, char* name, int name_len, char* comment, int comment_len)
+{
+	inflate_state* inf = (inflate_state*)stream;
+	isal_gzip_header* gh = (isal_gzip_header*)h;
+
+	// The buffers are handed in on every call since they may have been
+	// grown after an overflow; ISA-L carries on where it left off.
+	gh->extra = extra;
+	gh->extra_buf_len = extra_len;
+	gh->name = name;
+	gh->name_buf_len = name_len;
+	gh->comment = comment;
+	gh->comment_buf_len = comment_len;
 
+	inf->next_in = in;
+	inf->avail_in = *avail_in;
+
+	int ret = I_isal_read_gzip_header(inf, gh);
+
+	*avail_in = inf->avail_in;
+	return ret;
+}
+
+
+int ig_isal_write_zlib_header(char* stream, uint8_t* out, int* avail_out, int info, int level, int dict_flag, uint32_t dict_id)
+{
 	isal_zstream* zs = (isal_zstream*)stream;
-	isal_gzip_header* gh = (isal_gzip_header*)header;
+	struct isal_zlib_header zh;
 
-	zs->avail_in = in_bytes;
-	zs->next_in = in;
+	zh.info = info;
+	zh.level = level;
+	zh.dict_flag = dict_flag;
+	zh.dict_id = dict_id;
 
 	zs->next_out = out;
-	zs->avail_out = *out_bytes;
+	zs->avail_out = *avail_out;
 
-	if( isheader == 1  ) 
-	{
-		zs->gzip_flag = IGZIP_GZIP_NO_HDR;
-		I_isal_write_gzip_header(zs, gh);
-	}
-	int ret = I_isal_deflate_stateless(zs);
+	int ret = I_isal_write_zlib_header(zs, &zh);
 
-	assert(zs->avail_in == 0);
-	*out_bytes = zs->avail_out;
+	*avail_out = zs->avail_out;
+	return ret;
+}
+
+
+int ig_isal_deflate_set_dict(char* stream, uint8_t* dict, int dict_len, uint8_t* level_buf)
+{
+	isal_zstream* zs = (isal_zstream*)stream;
+
+	if (level_buf != NULL) zs->level_buf = level_buf;
+	int ret = I_isal_deflate_set_dict(zs, dict, dict_len);
+	if (level_buf != NULL) zs->level_buf = NULL;
 
 	return ret;
 }
 
 
-int ig_isal_deflate(char* stream,uint8_t* in,uint8_t* out, int* avail_out, int* end_of_stream, int* state, int* avail_in,int isHeader, char* header)
+int ig_isal_inflate_set_dict(char* stream, uint8_t* dict, int dict_len)
+{
+	inflate_state* inf = (inflate_state*)stream;
+
+	return I_isal_inflate_set_dict(inf, dict, dict_len);
+}
+
+
+int ig_isal_deflate_process_dict(char* stream, char* dict_str, uint8_t* dict, int dict_len)
+{
+	isal_zstream* zs = (isal_zstream*)stream;
+
+	return I_isal_deflate_process_dict(zs, (struct isal_dict*)dict_str, dict, dict_len);
+}
+
+
+int ig_isal_deflate_reset_dict(char* stream, char* dict_str, uint8_t* level_buf)
 {
+	isal_zstream* zs = (isal_zstream*)stream;
 
+	if (level_buf != NULL) zs->level_buf = level_buf;
+	int ret = I_isal_deflate_reset_dict(zs, (struct isal_dict*)dict_str);
+	if (level_buf != NULL) zs->level_buf = NULL;
 
+	return ret;
+}
+
+
+int ig_isal_deflate_stateless(char* stream, uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int gzip_flag, uint8_t* level_buf)
+{
 	isal_zstream* zs = (isal_zstream*)stream;
 
-	isal_gzip_header* gh = (isal_gzip_header*)header;
-	zs->avail_in = *avail_in;
+	if (level_buf != NULL) zs->level_buf = level_buf;
 
-	zs->end_of_stream = *end_of_stream;
+	zs->next_in = in;
+	zs->avail_in = in_bytes;
 	zs->next_out = out;
 	zs->avail_out = *avail_out;
-	zs->gzip_flag = IGZIP_GZIP_NO_HDR;
+	zs->flush = NO_FLUSH;
+	zs->end_of_stream = 1;
+	zs->gzip_flag = gzip_flag;
 
+	int ret = I_isal_deflate_stateless(zs);
 
-	if(zs->total_in ==0){
-		zs->next_in = in;
-		if( isHeader == 1  )
-		{
-			zs->gzip_flag = IGZIP_GZIP_NO_HDR;
+	if (level_buf != NULL) zs->level_buf = NULL;
 
-			I_isal_write_gzip_header(zs, gh);
-		}
+	*avail_out = zs->avail_out;
 
-	}
+	return ret;
+}
 
-	int ret;
 
+int ig_isal_deflate(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int flush, int end_of_stream, int* state, int gzip_flag, char* hufftables, uint8_t* level_buf)
+{
+	isal_zstream* zs = (isal_zstream*)stream;
+
+	// Custom tables and a Go-owned level buffer live in Go memory, so they
+	// are handed over on every call rather than kept in the stream.
+	struct isal_hufftables* prev_hufftables = zs->hufftables;
+	if (hufftables != NULL) zs->hufftables = (struct isal_hufftables*)hufftables;
+	if (level_buf != NULL) zs->level_buf = level_buf;
+
+	zs->next_in = in;
+	zs->avail_in = *avail_in;
+	zs->next_out = out;
+	zs->avail_out = *avail_out;
+	zs->flush = flush;
+	zs->end_of_stream = end_of_stream;
+	zs->gzip_flag = gzip_flag;
+
+	int ret = I_isal_deflate(zs);
 
-	ret = 	I_isal_deflate(zs);
+	zs->hufftables = prev_hufftables;
+	if (level_buf != NULL) zs->level_buf = NULL;
 
 	*avail_out = zs->avail_out;
 	*avail_in = zs->avail_in;
-	if(zs->internal_state.state == ZSTATE_END) *state = 1;
+	if (zs->internal_state.state == ZSTATE_END) *state = 1;
 
 	return ret;
 }
@@ -230,35 +415,41 @@ int ig_isal_inflate_init(char* stream) {
 }
 
 
-int ig_isal_inflate_stateless(char * stream,uint8_t* in, int in_bytes, uint8_t* out, int* out_bytes, int* state, int* avail_in,int isHeader, char* header) {
-
+int ig_isal_inflate_stateless(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int crc_flag)
+{
 	inflate_state *inf = (inflate_state*) stream;
-	isal_gzip_header* gh = (isal_gzip_header*)header;
-
-	int ret;
 
+	I_isal_inflate_init(inf);
+	inf->next_in = in;
 	inf->avail_in = *avail_in;
-
 	inf->next_out = out;
-	inf->avail_out = *out_bytes;
+	inf->avail_out = *avail_out;
+	inf->crc_flag = crc_flag;
 
+	int ret = I_isal_inflate_stateless(inf);
 
-	inf->next_in = in;
-	if( isHeader == 1  )
-	{
-		inf->crc_flag = IGZIP_GZIP;
+	*avail_in = inf->avail_in;
+	*avail_out = inf->avail_out;
 
-		I_isal_read_gzip_header(inf, gh);
-	}
+	return ret;
+}
 
 
+int ig_isal_inflate(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int crc_flag, int hist_bits, int* state) {
 
+	inflate_state *inf = (inflate_state*) stream;
 
-	ret = I_isal_inflate_stateless(inf);
+	inf->next_in = in;
+	inf->avail_in = *avail_in;
+	inf->next_out = out;
+	inf->avail_out = *avail_out;
+	inf->crc_flag = crc_flag;
+	inf->hist_bits = hist_bits;
 
+	int ret = I_isal_inflate(inf);
 
-	*out_bytes = inf->avail_out;
 	*avail_in = inf->avail_in;
+	*avail_out = inf->avail_out;
 	if(inf->block_state == ISAL_BLOCK_FINISH) *state = 1;
 
 	return ret;
@@ -266,70 +457,31 @@ int ig_isal_inflate_stateless(char * stream,uint8_t* in, int in_bytes, uint8_t*
 }
 
 
-int ig_isal_inflate(char * stream,uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int* total_out, int* state, int* avail_in,int isHeader, char* header) {
-
-        inflate_state *inf = (inflate_state*) stream;
-        isal_gzip_header* gh = (isal_gzip_header*)header;
-
-        int ret;
-
-
-
-        inf->avail_in = *avail_in;
-
-        inf->next_in = in;
-
-        if(inf->avail_out == 0) inf->avail_out = *avail_out;
-
-        if(inf->next_out == NULL) {
-              inf->next_out = out;
-                if(isHeader ==1) inf->crc_flag  = IGZIP_GZIP;
-                if(isHeader ==1 ) I_isal_read_gzip_header(inf, gh);
-        }
-
-        ret = I_isal_inflate(inf);
-
-        *avail_out = inf->avail_out;
-        *total_out = inf->total_out;
-        *avail_in = inf->avail_in;
-        if(inf->block_state == ISAL_BLOCK_FINISH) *state = 1;
-
-        return ret;
-
+void ig_isal_update_histogram(uint8_t* in, int length, char* histogram)
+{
+	I_isal_update_histogram(in, length, (struct isal_huff_histogram*)histogram);
 }
 
 
+int ig_isal_create_hufftables(char* hufftables, char* histogram, int subset)
+{
+	struct isal_hufftables* h = (struct isal_hufftables*)hufftables;
+	struct isal_huff_histogram* hist = (struct isal_huff_histogram*)histogram;
 
-int ig_isal_inflate_buffered(char * stream,uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int* total_out, int* state, int* avail_in,int isHeader, char* header) {
-
-	inflate_state *inf = (inflate_state*) stream;
-	isal_gzip_header* gh = (isal_gzip_header*)header;
-
-	int ret;
-
-
-
-	inf->avail_in = *avail_in;
-
-	inf->next_in = in;
-        if(*total_out == 0) inf->total_out =0; 
-	inf->avail_out = *avail_out;
+	if (subset) return I_isal_create_hufftables_subset(h, hist);
+	return I_isal_create_hufftables(h, hist);
+}
 
-	if(inf->next_out == NULL) {
-		if(isHeader ==1) inf->crc_flag  = IGZIP_GZIP;
-		if(isHeader ==1 )I_isal_read_gzip_header(inf, gh);
-	}
 
-	inf->next_out = out;
-	ret = I_isal_inflate(inf);
+int ig_isal_deflate_set_hufftables(char* stream, char* hufftables, int type)
+{
+	isal_zstream* zs = (isal_zstream*)stream;
+	struct isal_hufftables* prev_hufftables = zs->hufftables;
 
-	*avail_out = inf->avail_out;
-	*total_out = inf->total_out;
-	*avail_in = inf->avail_in;
-	if(inf->block_state == ISAL_BLOCK_FINISH) *state = 1;
+	int ret = I_isal_deflate_set_hufftables(zs, (struct isal_hufftables*)hufftables, type);
 
+	// Custom tables are Go memory, which ig_isal_deflate hands over per
+	// call; the stream keeps the tables it had.
+	if (type == IGZIP_HUFFTABLE_CUSTOM) zs->hufftables = prev_hufftables;
 	return ret;
-
 }
-
-
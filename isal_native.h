@@ -3,9 +3,28 @@
 
 typedef struct {
 	const char *name;
-	void **func;
+	void **func;    // NULL for a symbol that is only probed for
+	int feature;    // IG_FEATURE_* the symbol belongs to, 0 if required
 } symbol_info_t;
 
+// Optional groups of ISA-L functions. A feature is available only if the
+// library has all of its symbols.
+#define IG_FEATURE_DICT         (1 << 0)
+#define IG_FEATURE_DICT_PROCESS (1 << 1)
+#define IG_FEATURE_HUFFMAN      (1 << 2)
+#define IG_FEATURE_ZLIB_HEADER  (1 << 3)
+#define IG_FEATURE_CRC          (1 << 4)
+#define IG_FEATURE_EC           (1 << 5)
+#define IG_FEATURE_ALL          ((1 << 6) - 1)
+
+// IG_STATIC is 1 when ISA-L is linked in (build tag isal_static) rather than
+// loaded with dlopen.
+#ifdef ISAL_STATIC
+#define IG_STATIC 1
+#else
+#define IG_STATIC 0
+#endif
+
 //libisal.so definitions
 #define ISAL_LIB "libisal.so"
 
@@ -18,29 +37,45 @@ typedef int (*I_isal_inflate_stateless_t)(struct inflate_state * stream);
 typedef void *(*I_isal_gzip_header_init_t)(struct isal_gzip_header * stream);
 typedef int (*I_isal_read_gzip_header_t)(struct inflate_state *state, struct isal_gzip_header *gz_hdr);
 typedef int (*I_isal_write_gzip_header_t)(struct isal_zstream * stream, struct isal_gzip_header *gz_hdr);
+typedef int (*I_isal_write_zlib_header_t)(struct isal_zstream * stream, struct isal_zlib_header *z_hdr);
+typedef int (*I_isal_deflate_set_dict_t)(struct isal_zstream * stream, uint8_t *dict, uint32_t dict_len);
+typedef int (*I_isal_inflate_set_dict_t)(struct inflate_state * state, uint8_t *dict, uint32_t dict_len);
+typedef int (*I_isal_deflate_process_dict_t)(struct isal_zstream * stream, struct isal_dict *dict_str, uint8_t *dict, uint32_t dict_len);
+typedef int (*I_isal_deflate_reset_dict_t)(struct isal_zstream * stream, struct isal_dict *dict_str);
+typedef void (*I_isal_update_histogram_t)(uint8_t * in_stream, int length, struct isal_huff_histogram * histogram);
+typedef int (*I_isal_create_hufftables_t)(struct isal_hufftables * hufftables, struct isal_huff_histogram * histogram);
+typedef int (*I_isal_deflate_set_hufftables_t)(struct isal_zstream * stream, struct isal_hufftables *hufftables, int type);
 
 
-extern int isal_dload_functions();
-extern int isal_dload_symbols(void *handle, symbol_info_t * symbols, int num_symbols);
+extern int isal_dload_functions(const char *path, const char **missing, char *err, int err_len, int *features, char *file, int file_len);
+extern int isal_dload_symbols(void *handle, symbol_info_t * symbols, int num_symbols, const char **missing, char *err, int err_len, int *features);
 
 
 struct zng_gz_header_s;
 extern int ig_isal_inflate_init(char* stream);
 extern void ig_isal_inflate_reset(char* stream);
 extern int ig_isal_inflate_end(char* stream);
-extern int ig_isal_inflate(char* stream,uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int* total_out, int* state, int* avail_in, int isheader, char* gheader);
-extern int ig_isal_inflate_buffered(char* stream,uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int* total_out, int* state, int* avail_in, int isheader, char* gheader);
-extern int ig_isal_inflate_stateless(char* stream,uint8_t* in, int in_bytes, uint8_t* out, int* out_bytes, int* state, int* avail_in, int isheader, char* gheader);
+extern int ig_isal_inflate(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int crc_flag, int hist_bits, int* state);
+extern int ig_isal_inflate_stateless(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int crc_flag);
 
-// format is one of Gzip or Flate.
 extern int ig_isal_gzip_header_init(char* h);
-extern int ig_isal_deflate_init(char* stream,int level);
+extern int ig_isal_deflate_init(char* stream, int level, int hist_bits, int level_buf_size, int go_level_buf);
 extern void ig_isal_deflate_reset(char* stream);
-extern int ig_isal_gzip_set_header(char* stream, char* h);
-extern int ig_isal_deflate_stateless(char* stream,uint8_t* in, int in_bytes, uint8_t* out,
-                      int* out_bytes,int* consumed_input, int isheader, char* header);
+extern int ig_isal_write_gzip_header(char* stream, uint8_t* out, int* avail_out, uint32_t time, int os,
+                      uint8_t* extra, int extra_len, char* name, char* comment);
+extern int ig_isal_read_gzip_header(char* stream, uint8_t* in, int* avail_in, char* h,
+                      uint8_t* extra, int extra_len, char* name, int name_len, char* comment, int comment_len);
+extern int ig_isal_write_zlib_header(char* stream, uint8_t* out, int* avail_out, int info, int level, int dict_flag, uint32_t dict_id);
+extern int ig_isal_deflate_set_dict(char* stream, uint8_t* dict, int dict_len, uint8_t* level_buf);
+extern int ig_isal_inflate_set_dict(char* stream, uint8_t* dict, int dict_len);
+extern int ig_isal_deflate_process_dict(char* stream, char* dict_str, uint8_t* dict, int dict_len);
+extern int ig_isal_deflate_reset_dict(char* stream, char* dict_str, uint8_t* level_buf);
+extern int ig_isal_deflate_stateless(char* stream, uint8_t* in, int in_bytes, uint8_t* out, int* avail_out, int gzip_flag, uint8_t* level_buf);
 extern int ig_isal_deflate_end(char* stream);
-extern int ig_isal_deflate(char* stream,uint8_t* in,uint8_t* out, int* avail_out, int* end_of_stream, int* state, int* avail_in, int isheader, char* header);
+extern int ig_isal_deflate(char* stream, uint8_t* in, int* avail_in, uint8_t* out, int* avail_out, int flush, int end_of_stream, int* state, int gzip_flag, char* hufftables, uint8_t* level_buf);
+extern void ig_isal_update_histogram(uint8_t* in, int length, char* histogram);
+extern int ig_isal_create_hufftables(char* hufftables, char* histogram, int subset);
+extern int ig_isal_deflate_set_hufftables(char* stream, char* hufftables, int type);
 
 
 
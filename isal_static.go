@@ -0,0 +1,13 @@
+//go:build isal_static
+
+package isal
+
+// With the isal_static build tag ISA-L is linked into the binary from its
+// static archive, libisal.a, instead of being loaded from libisal.so at run
+// time. The ig_isal_* shims are the same either way. If the archive is not in
+// the linker's default search path, point to it with CGO_LDFLAGS=-L<dir>; a
+// fully static binary also needs -ldflags '-extldflags -static'.
+
+//#cgo CFLAGS: -DISAL_STATIC
+//#cgo LDFLAGS: -l:libisal.a
+import "C"
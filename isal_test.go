@@ -1,16 +1,27 @@
+//go:build cgo
+
 package isal
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 var strGettysBurgAddress = "" +
@@ -135,7 +146,1219 @@ func TestCompressVerify(t *testing.T) {
 	fmt.Printf("Finished compress verify test\n")
 }
 
+func TestCompressStreamSingleMember(t *testing.T) {
+	b := bytes.Repeat([]byte(strGettysBurgAddress), 200)
+	cbuf := new(bytes.Buffer)
+
+	z, err := NewWriterLevel(cbuf, 1)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	// Many small writes must still produce a single gzip member.
+	for off := 0; off < len(b); off += 1000 {
+		end := off + 1000
+		if end > len(b) {
+			end = len(b)
+		}
+		if _, err := z.Write(b[off:end]); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	g, err := gzip.NewReader(cbuf)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	g.Multistream(false)
+
+	got, err := io.ReadAll(g)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if !bytes.Equal(b, got) {
+		t.Fatalf("Testfail: mismatch len(b):%d len(got):%d", len(b), len(got))
+	}
+	if cbuf.Len() != 0 {
+		t.Fatalf("Testfail: %d bytes left after the first gzip member", cbuf.Len())
+	}
+
+	if _, err := z.Write(b); err != errWriterClosed {
+		t.Fatalf("Testfail: Write after Close returned %v", err)
+	}
+}
+
+// runFlushTest writes chunks with a flush after each. With full set, flush
+// must drop the history.
+func runFlushTest(t *testing.T, flush func(z *Writer) error, full bool) {
+	cbuf := new(bytes.Buffer)
+	z, err := NewWriterLevel(cbuf, 1)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	var written []byte
+	flushed := 0 // the output size at the last flush
+	for i := 0; i < 3; i++ {
+		chunk := []byte(fmt.Sprintf("chunk %d: %s", i, strGettysBurgAddress))
+		if _, err := z.Write(chunk); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if err := flush(z); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		written = append(written, chunk...)
+
+		// The chunks repeat each other, so only a stream that refers back to
+		// nothing before the flush point decodes from there on its own.
+		if full && i > 0 {
+			f := flate.NewReader(bytes.NewReader(cbuf.Bytes()[flushed:]))
+			got := make([]byte, len(chunk))
+			if _, err := io.ReadFull(f, got); err != nil || !bytes.Equal(got, chunk) {
+				t.Fatalf("Testfail: chunk %d does not decode on its own after the full flush: %v", i, err)
+			}
+		}
+		flushed = cbuf.Len()
+
+		// Everything written so far must be decodable without the trailer.
+		g, err := gzip.NewReader(bytes.NewReader(cbuf.Bytes()))
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		got := make([]byte, len(written))
+		if _, err := io.ReadFull(g, got); err != nil {
+			t.Fatalf("Testfail: after flush %d: %v", i, err)
+		}
+		if !bytes.Equal(got, written) {
+			t.Fatalf("Testfail: mismatch after flush %d", i)
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if err := flush(z); err != nil {
+		t.Fatalf("Testfail: flush after Close returned %v", err)
+	}
+
+	g, err := gzip.NewReader(cbuf)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	got, err := io.ReadAll(g)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if !bytes.Equal(got, written) {
+		t.Fatal("Testfail: mismatch after Close")
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	runFlushTest(t, (*Writer).Flush, false)
+}
+
+func TestWriterFlushFull(t *testing.T) {
+	runFlushTest(t, (*Writer).FlushFull, true)
+}
+
+func TestZlibCompress(t *testing.T) {
+	for _, l := range levelTests {
+		b := new(bytes.Buffer)
+		z, err := NewZlibWriterLevel(b, l.level)
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		z.Write(textTwain)
+		if err := z.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+
+		// validate with compress/zlib
+		r, err := zlib.NewReader(b)
+		if err != nil {
+			t.Fatalf("Testfail: %s: %v", l.name, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Testfail: %s: %v", l.name, err)
+		}
+		if !bytes.Equal(got, textTwain) {
+			t.Fatalf("Testfail: %s: mismatch", l.name)
+		}
+	}
+}
+
+func TestZlibDecompress(t *testing.T) {
+	b := new(bytes.Buffer)
+	w := zlib.NewWriter(b)
+	w.Write([]byte(strGettysBurgAddress))
+	w.Close()
+
+	z, err := NewZlibReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	s := new(bytes.Buffer)
+	if _, err := io.Copy(s, z); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if s.String() != strGettysBurgAddress {
+		t.Fatalf("mismatch\n***expected***\n%q\n\n ***received***\n%q", strGettysBurgAddress, s)
+	}
+	z.Close()
+
+	// A damaged Adler-32 trailer must not go unnoticed.
+	corrupt := append([]byte(nil), b.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	z, err = NewZlibReader(bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if _, err := io.Copy(io.Discard, z); err == nil {
+		t.Fatal("Testfail: corrupt adler32 trailer was not detected")
+	}
+	z.Close()
+}
+
+func TestFormats(t *testing.T) {
+	src := []byte(strGettysBurgAddress)
+	compressed := make(map[Format][]byte)
+
+	for _, f := range []Format{Gzip, Zlib, Deflate, GzipNoHeader, ZlibNoHeader} {
+		b := new(bytes.Buffer)
+		z, err := NewWriterLevel(b, 1, Opts{Format: f})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		z.Write(src)
+		if err := z.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		compressed[f] = b.Bytes()
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()), Opts{Format: f})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Testfail: format %d: %v", f, err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("Testfail: format %d: mismatch", f)
+		}
+		r.Close()
+	}
+
+	// validate raw deflate with compress/flate, both ways
+	got, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed[Deflate])))
+	if err != nil || !bytes.Equal(got, src) {
+		t.Fatalf("Testfail: compress/flate could not read raw deflate output: %v", err)
+	}
+	b := new(bytes.Buffer)
+	fw, _ := flate.NewWriter(b, 5)
+	fw.Write(src)
+	fw.Close()
+	r, _ := NewReader(b, Opts{Format: Deflate})
+	got, err = io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, src) {
+		t.Fatalf("Testfail: could not read compress/flate output: %v", err)
+	}
+
+	// The headerless variants are the full formats minus the default headers.
+	if !bytes.Equal(compressed[GzipNoHeader], compressed[Gzip][10:]) {
+		t.Fatal("Testfail: GzipNoHeader is not gzip without its header")
+	}
+	if !bytes.Equal(compressed[ZlibNoHeader], compressed[Zlib][2:]) {
+		t.Fatal("Testfail: ZlibNoHeader is not zlib without its header")
+	}
+
+	if _, err := NewWriterLevel(io.Discard, 1, Opts{Format: -1}); err == nil {
+		t.Fatal("Testfail: invalid format accepted")
+	}
+	if _, err := NewReader(b, Opts{}, Opts{}); err == nil {
+		t.Fatal("Testfail: two Opts accepted")
+	}
+}
+
+func TestWriterHeader(t *testing.T) {
+	b := new(bytes.Buffer)
+	z, err := NewWriterLevel(b, 1)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	z.Name = "gettysburg.txt"
+	z.Comment = "Abraham Lincoln, 1863, café"
+	z.ModTime = time.Unix(1700000000, 0)
+	z.Extra = []byte("extra field")
+	z.OS = 3
+	z.Write([]byte(strGettysBurgAddress))
+	if err := z.Close(); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	// validate with compress/gzip
+	g, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if g.Name != z.Name || g.Comment != z.Comment || !g.ModTime.Equal(z.ModTime) ||
+		!bytes.Equal(g.Extra, z.Extra) || g.OS != z.OS {
+		t.Fatalf("Testfail: header mismatch\nwrote %+v\nread  %+v", z.Header, g.Header)
+	}
+	got, err := io.ReadAll(g)
+	if err != nil || string(got) != strGettysBurgAddress {
+		t.Fatalf("Testfail: body mismatch: %v", err)
+	}
+
+	z, _ = NewWriterLevel(io.Discard, 1)
+	z.Name = "\u4e16\u754c"
+	if _, err := z.Write([]byte("x")); err == nil {
+		t.Fatal("Testfail: non-Latin-1 name accepted")
+	}
+}
+
+func TestReaderHeader(t *testing.T) {
+	want := gzip.Header{
+		// Longer than the initial name buffer, so ISA-L overflows it.
+		Name:    strings.Repeat("long-file-name-", 40) + "é.txt",
+		Comment: "a comment",
+		ModTime: time.Unix(1600000000, 0),
+		Extra:   []byte{1, 2, 3, 4},
+		OS:      3,
+	}
+
+	b := new(bytes.Buffer)
+	g := gzip.NewWriter(b)
+	g.Header = want
+	g.Write([]byte(strGettysBurgAddress))
+	g.Close()
+
+	z, err := NewReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if z.Name != want.Name || z.Comment != want.Comment || !z.ModTime.Equal(want.ModTime) ||
+		!bytes.Equal(z.Extra, want.Extra) || z.OS != want.OS {
+		t.Fatalf("Testfail: header mismatch\nwrote %+v\nread  %+v", want, z.Header)
+	}
+	got, err := io.ReadAll(z)
+	if err != nil || string(got) != strGettysBurgAddress {
+		t.Fatalf("Testfail: body mismatch: %v", err)
+	}
+	z.Close()
+
+	if _, err := NewReader(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("Testfail: empty input returned %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(b.Bytes()[:5])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Testfail: truncated header returned %v", err)
+	}
+}
+
+func TestReaderMultistream(t *testing.T) {
+	big := []byte(strings.Repeat(strGettysBurgAddress, 200))
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"first", []byte(strGettysBurgAddress)},
+		{"second", big},
+		{"empty", nil},
+		{"last", []byte("the end")},
+	}
+
+	// Mix members written by this package and by compress/gzip.
+	b := new(bytes.Buffer)
+	var want []byte
+	for i, m := range members {
+		if i%2 == 0 {
+			w, _ := NewWriter(b)
+			w.Name = m.name
+			w.Write(m.data)
+			w.Close()
+		} else {
+			g := gzip.NewWriter(b)
+			g.Name = m.name
+			g.Write(m.data)
+			g.Close()
+		}
+		want = append(want, m.data...)
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"plain":   func(r io.Reader) io.Reader { return r },
+		"onebyte": iotest.OneByteReader,
+	}
+	for name, wrap := range readers {
+		// io.ReadAll starts with small reads, io.CopyBuffer makes large ones.
+		z, err := NewReader(wrap(bytes.NewReader(b.Bytes())))
+		if err != nil {
+			t.Fatal("Testfail:", name, err)
+		}
+		got, err := io.ReadAll(z)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("Testfail: %s: got %d bytes, want %d: %v", name, len(got), len(want), err)
+		}
+		if z.Name != "last" {
+			t.Errorf("Testfail: %s: header of the last member not read, Name = %q", name, z.Name)
+		}
+		z.Close()
+
+		z, _ = NewReader(wrap(bytes.NewReader(b.Bytes())))
+		out := new(bytes.Buffer)
+		if _, err := io.CopyBuffer(struct{ io.Writer }{out}, struct{ io.Reader }{z}, make([]byte, 64*1024)); err != nil || !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("Testfail: %s: large reads got %d bytes, want %d: %v", name, out.Len(), len(want), err)
+		}
+		z.Close()
+
+		z, _ = NewReader(wrap(bytes.NewReader(b.Bytes())))
+		z.Multistream(false)
+		got, err = io.ReadAll(z)
+		if err != nil || string(got) != strGettysBurgAddress || z.Name != "first" {
+			t.Fatalf("Testfail: %s: Multistream(false) read %d bytes, Name %q: %v", name, len(got), z.Name, err)
+		}
+		z.Close()
+	}
+
+	// Data after the last member that is not a gzip header is an error.
+	z, _ := NewReader(bytes.NewReader(append(b.Bytes(), "garbage"...)))
+	if _, err := io.ReadAll(z); err == nil {
+		t.Fatal("Testfail: trailing garbage accepted")
+	}
+	z.Close()
+}
+
+func TestReaderStreaming(t *testing.T) {
+	// A stream that inflates to far more than the Reader's buffers.
+	line := []byte(strings.Repeat("all work and no play makes jack a dull boy ", 2))
+	const lines = 1 << 19
+	b := new(bytes.Buffer)
+	g, _ := gzip.NewWriterLevel(b, gzip.BestSpeed)
+	for i := 0; i < lines; i++ {
+		g.Write(line)
+		g.Write([]byte("\n"))
+	}
+	g.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	z, err := NewReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	s := bufio.NewScanner(z)
+	n := 0
+	for s.Scan() {
+		if !bytes.Equal(s.Bytes(), line) {
+			t.Fatalf("Testfail: line %d is %q", n, s.Bytes())
+		}
+		n++
+	}
+	if err := s.Err(); err != nil || n != lines {
+		t.Fatalf("Testfail: scanned %d lines, want %d: %v", n, lines, err)
+	}
+	z.Close()
+	runtime.ReadMemStats(&after)
+	if total := after.TotalAlloc - before.TotalAlloc; total > 4*D_BUF_SIZE {
+		t.Errorf("Testfail: reading %d bytes allocated %d bytes", lines*(len(line)+1), total)
+	}
+
+	// Every read size gets at most len(p) bytes and the data comes out whole.
+	small := new(bytes.Buffer)
+	w, _ := NewWriter(small)
+	w.Write([]byte(strGettysBurgAddress))
+	w.Close()
+	z, _ = NewReader(bytes.NewReader(small.Bytes()))
+	if err := iotest.TestReader(z, []byte(strGettysBurgAddress)); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	z.Close()
+
+	z, _ = NewReader(bytes.NewReader(small.Bytes()))
+	z.Close()
+	if _, err := z.Read(make([]byte, 1)); err != errReaderClosed {
+		t.Fatalf("Testfail: Read after Close returned %v", err)
+	}
+}
+
+func TestReaderTrailer(t *testing.T) {
+	b := new(bytes.Buffer)
+	g := gzip.NewWriter(b)
+	g.Write([]byte(strGettysBurgAddress))
+	g.Close()
+	good := b.Bytes()
+
+	readAll := func(data []byte) error {
+		z, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		_, err = io.ReadAll(z)
+		return err
+	}
+
+	if err := readAll(good); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	// Flip a bit of the CRC-32 and of ISIZE in turn.
+	for _, i := range []int{len(good) - 8, len(good) - 1} {
+		bad := append([]byte(nil), good...)
+		bad[i] ^= 1
+		if err := readAll(bad); !errors.Is(err, ErrChecksum) {
+			t.Errorf("Testfail: corrupt trailer byte %d returned %v", i, err)
+		}
+	}
+
+	// A bad trailer in a later member is caught too.
+	bad := append(append([]byte(nil), good...), good...)
+	bad[len(bad)-5] ^= 1
+	if err := readAll(bad); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Testfail: corrupt second member returned %v", err)
+	}
+
+	// Truncation in the body or in the trailer.
+	for _, n := range []int{len(good) / 2, len(good) - 8, len(good) - 1} {
+		if err := readAll(good[:n]); err != io.ErrUnexpectedEOF {
+			t.Errorf("Testfail: input truncated to %d bytes returned %v", n, err)
+		}
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	// A stored block holding "hello" followed by a block of the reserved
+	// type 3.
+	bad := []byte{0x00, 0x05, 0x00, 0xfa, 0xff, 'h', 'e', 'l', 'l', 'o', 0x07}
+	z, err := NewReader(bytes.NewReader(bad), Opts{Format: Deflate})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	_, err = io.ReadAll(z)
+	z.Close()
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("Testfail: invalid block returned %v", err)
+	}
+	var ce *CodecError
+	if !errors.As(err, &ce) || ce.Code != -1 || ce.Offset < 10 || ce.Offset > int64(len(bad)) {
+		t.Fatalf("Testfail: unexpected CodecError %#v", ce)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("\x1f\x8c\x08\x00\x00\x00\x00\x00\x00\xff"))); !errors.Is(err, ErrInvalidWrapper) {
+		t.Fatalf("Testfail: bad gzip magic returned %v", err)
+	}
+	if _, err := NewWriterLevel(io.Discard, 9); !errors.Is(err, ErrInvalidLevel) {
+		t.Fatalf("Testfail: level 9 returned %v", err)
+	}
+}
+
+func TestDict(t *testing.T) {
+	dict := []byte(`{"id":0,"name":"","email":"","created_at":"2020-01-01T00:00:00Z","tags":[]}`)
+	record := []byte(`{"id":42,"name":"Ada","email":"ada@example.com","created_at":"2021-06-01T12:00:00Z","tags":["x"]}`)
+
+	for _, f := range []Format{Gzip, Zlib, Deflate} {
+		plain := new(bytes.Buffer)
+		w, _ := NewWriterLevel(plain, 1, Opts{Format: f})
+		w.Write(record)
+		w.Close()
+
+		b := new(bytes.Buffer)
+		w, err := NewWriterDict(b, 1, dict, Opts{Format: f})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		w.Write(record)
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if b.Len() >= plain.Len() {
+			t.Errorf("Testfail: format %d: %d bytes with a dictionary, %d without", f, b.Len(), plain.Len())
+		}
+
+		z, err := NewReaderDict(bytes.NewReader(b.Bytes()), dict, Opts{Format: f})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		got, err := io.ReadAll(z)
+		z.Close()
+		if err != nil || !bytes.Equal(got, record) {
+			t.Fatalf("Testfail: format %d: round trip failed: %v", f, err)
+		}
+
+		switch f {
+		case Zlib:
+			zr, err := zlib.NewReaderDict(bytes.NewReader(b.Bytes()), dict)
+			if err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, record) {
+				t.Fatalf("Testfail: compress/zlib could not read the output: %v", err)
+			}
+
+			z, _ := NewZlibReader(bytes.NewReader(b.Bytes()))
+			if _, err := io.ReadAll(z); !errors.Is(err, ErrNeedDict) {
+				t.Fatalf("Testfail: missing dictionary returned %v", err)
+			}
+			z.Close()
+		case Deflate:
+			fr := flate.NewReaderDict(bytes.NewReader(b.Bytes()), dict)
+			if got, err := io.ReadAll(fr); err != nil || !bytes.Equal(got, record) {
+				t.Fatalf("Testfail: compress/flate could not read the output: %v", err)
+			}
+		}
+	}
+
+	// And the other way around.
+	b := new(bytes.Buffer)
+	zw, _ := zlib.NewWriterLevelDict(b, zlib.BestCompression, dict)
+	zw.Write(record)
+	zw.Close()
+	z, _ := NewReaderDict(bytes.NewReader(b.Bytes()), dict, Opts{Format: Zlib})
+	if got, err := io.ReadAll(z); err != nil || !bytes.Equal(got, record) {
+		t.Fatalf("Testfail: reading compress/zlib output failed: %v", err)
+	}
+	z.Close()
+}
+
+func TestDictionary(t *testing.T) {
+	dict := []byte(`{"id":0,"name":"","email":"","created_at":"2020-01-01T00:00:00Z","tags":[]}`)
+	d, err := NewDictionary(dict, 2)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	// One Dictionary shared by writers on several goroutines, each reset
+	// onto it for every message.
+	errc := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			var w *Writer
+			for i := 0; i < 20; i++ {
+				msg := []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","email":"u%d@example.com","created_at":"2021-06-01T12:00:00Z","tags":[]}`, i, g, i))
+				b := new(bytes.Buffer)
+				var err error
+				if w == nil {
+					w, err = NewWriterDictionary(b, d, Opts{Format: Zlib})
+				} else {
+					err = w.ResetDictionary(b, d)
+				}
+				if err != nil {
+					errc <- err
+					return
+				}
+				w.Write(msg)
+				if err := w.Close(); err != nil {
+					errc <- err
+					return
+				}
+				zr, err := zlib.NewReaderDict(b, dict)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, msg) {
+					errc <- fmt.Errorf("message %d: %q, %v", i, got, err)
+					return
+				}
+			}
+			errc <- nil
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		if err := <-errc; err != nil {
+			t.Fatal("Testfail:", err)
+		}
+	}
+
+	w, _ := NewWriterLevel(io.Discard, 1)
+	if err := w.ResetDictionary(io.Discard, d); err != errDictLevel {
+		t.Fatalf("Testfail: level mismatch returned %v", err)
+	}
+
+	// An empty dictionary is no dictionary, on every backend.
+	empty, err := NewDictionary(nil, 1)
+	if err != nil || empty.prepared != nil {
+		t.Fatalf("Testfail: empty dictionary returned %v", err)
+	}
+	for _, be := range backends() {
+		b := new(bytes.Buffer)
+		w, err := NewWriterDictionary(b, empty, Opts{Backend: be})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		w.Write(dict)
+		w.Close()
+		g, _ := gzip.NewReader(b)
+		if got, err := io.ReadAll(g); err != nil || !bytes.Equal(got, dict) {
+			t.Fatalf("Testfail: %v: round trip with an empty dictionary: %v", be, err)
+		}
+	}
+}
+
+// requireISAL skips tests of what only ISA-L does unless the library can be
+// loaded. Such tests pin their Writers and Readers to BackendISAL, so that
+// BackendAuto cannot run them on compress/flate.
+func requireISAL(t *testing.T) {
+	t.Helper()
+	if err := LoadDefault(); err != nil {
+		t.Skip("ISA-L cannot be loaded:", err)
+	}
+}
+
+func TestHuffmanTables(t *testing.T) {
+	requireISAL(t)
+	sample := func(i int) []byte {
+		return []byte(fmt.Sprintf("ts=%d host=web-%02d cpu=%d.%d mem=%d status=ok\n", 1600000000+i, i%16, i%100, i%10, 1000+i*7))
+	}
+	var h Histogram
+	for i := 0; i < 200; i++ {
+		if err := h.Add(sample(i)); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+	}
+	full, err := NewHuffmanTables(&h)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	subset, err := NewHuffmanTablesSubset(&h)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	var data []byte
+	for i := 200; i < 400; i++ {
+		data = append(data, sample(i)...)
+	}
+	// Level 0 compresses with the tables, level 1 ignores them but must
+	// still accept them; see HuffmanTables.
+	for _, tables := range []*HuffmanTables{full, subset} {
+		for level := 0; level <= 1; level++ {
+			b := new(bytes.Buffer)
+			w, err := NewWriterLevel(b, level, Opts{HuffmanTables: tables, Backend: BackendISAL})
+			if err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			w.Write(data[:len(data)/2])
+			w.Write(data[len(data)/2:])
+			if err := w.Close(); err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			g, err := gzip.NewReader(b)
+			if err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			if got, err := io.ReadAll(g); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Testfail: level %d: round trip failed: %v", level, err)
+			}
+		}
+	}
+}
+
+func TestHuffmanTablesProfile(t *testing.T) {
+	requireISAL(t)
+	var h Histogram
+	h.Add([]byte(strings.Repeat(strGettysBurgAddress, 4)))
+	tables, err := NewHuffmanTables(&h)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	blob, err := tables.MarshalBinary()
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+
+	loaded := new(HuffmanTables)
+	if err := loaded.UnmarshalBinary(blob); err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	compress := func(tables *HuffmanTables) []byte {
+		b := new(bytes.Buffer)
+		w, _ := NewWriterLevel(b, 0, Opts{HuffmanTables: tables, Backend: BackendISAL})
+		w.Write([]byte(strGettysBurgAddress))
+		w.Close()
+		return b.Bytes()
+	}
+	if !bytes.Equal(compress(tables), compress(loaded)) {
+		t.Fatal("Testfail: loaded tables compress differently")
+	}
+
+	bad := append([]byte(nil), blob...)
+	bad[huffHeaderSize+10] ^= 1
+	if err := new(HuffmanTables).UnmarshalBinary(bad); err != ErrHuffmanTables {
+		t.Errorf("Testfail: corrupt profile returned %v", err)
+	}
+	if err := new(HuffmanTables).UnmarshalBinary(blob[:len(blob)-1]); err != ErrHuffmanTables {
+		t.Errorf("Testfail: truncated profile returned %v", err)
+	}
+	// A well-formed profile from another version or layout.
+	for _, off := range []int{4, 8, 12} {
+		bad := append([]byte(nil), blob[:len(blob)-4]...)
+		bad[off]++
+		bad = binary.LittleEndian.AppendUint32(bad, crc32.ChecksumIEEE(bad))
+		if err := new(HuffmanTables).UnmarshalBinary(bad); err != ErrHuffmanTables {
+			t.Errorf("Testfail: header byte %d changed, got %v", off, err)
+		}
+	}
+}
+
+func TestHuffmanModes(t *testing.T) {
+	requireISAL(t)
+	msg := []byte(`{"op":"ping","seq":12345,"ok":true}`)
+	sizes := map[HuffmanMode]int{}
+	for _, mode := range []HuffmanMode{HuffmanDefault, HuffmanStatic} {
+		b := new(bytes.Buffer)
+		w, err := NewWriterLevel(b, 1, Opts{Format: Deflate, Huffman: mode, Backend: BackendISAL})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		w.Write(msg)
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		sizes[mode] = b.Len()
+		if got, err := io.ReadAll(flate.NewReader(b)); err != nil || !bytes.Equal(got, msg) {
+			t.Fatalf("Testfail: mode %d: round trip failed: %v", mode, err)
+		}
+	}
+	if sizes[HuffmanStatic] > len(msg)+5 {
+		t.Errorf("Testfail: static Huffman output of %d bytes for a %d byte message", sizes[HuffmanStatic], len(msg))
+	}
+
+	var h Histogram
+	h.Add(msg)
+	tables, _ := NewHuffmanTables(&h)
+	for _, o := range []Opts{{Huffman: HuffmanStatic, HuffmanTables: tables}, {Huffman: 7}} {
+		if _, err := NewWriterLevel(io.Discard, 1, o); err != errInvalidHuffman {
+			t.Errorf("Testfail: %+v returned %v", o, err)
+		}
+	}
+}
+
+func TestWindowBits(t *testing.T) {
+	requireISAL(t)
+	data := []byte(strings.Repeat(strGettysBurgAddress, 10))
+	for _, wb := range []int{8, 9, 12, 15} {
+		b := new(bytes.Buffer)
+		w, err := NewWriterLevel(b, 1, Opts{Format: Zlib, WindowBits: wb, Backend: BackendISAL})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if cinfo := int(b.Bytes()[0] >> 4); cinfo != wb-8 {
+			t.Errorf("Testfail: WindowBits %d wrote CINFO %d", wb, cinfo)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Testfail: WindowBits %d: compress/zlib failed: %v", wb, err)
+		}
+
+		z, err := NewReader(bytes.NewReader(b.Bytes()), Opts{Format: Zlib, WindowBits: wb, Backend: BackendISAL})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		if got, err := io.ReadAll(z); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Testfail: WindowBits %d: round trip failed: %v", wb, err)
+		}
+		z.Close()
+	}
+
+	for _, wb := range []int{-1, 7, 16} {
+		if _, err := NewWriterLevel(io.Discard, 1, Opts{WindowBits: wb}); err != errInvalidWindowBits {
+			t.Errorf("Testfail: WindowBits %d returned %v", wb, err)
+		}
+	}
+}
+
+func TestMemoryLevel(t *testing.T) {
+	data := []byte(strings.Repeat(strGettysBurgAddress, 50))
+	check := func(level int, o Opts) {
+		t.Helper()
+		b := new(bytes.Buffer)
+		w, err := NewWriterLevel(b, level, o)
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		g, _ := gzip.NewReader(b)
+		if got, err := io.ReadAll(g); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Testfail: level %d, memory level %d: round trip failed: %v", level, o.MemoryLevel, err)
+		}
+	}
+
+	for level := 1; level <= 3; level++ {
+		for m := MemoryMin; m <= MemoryExtraLarge; m++ {
+			if m > MemoryMin && LevelBufferSize(level, m) <= LevelBufferSize(level, m-1) {
+				t.Errorf("Testfail: level %d: memory level %d is not larger than %d", level, m, m-1)
+			}
+			check(level, Opts{MemoryLevel: m})
+		}
+		check(level, Opts{LevelBuffer: make([]byte, LevelBufferSize(level, MemorySmall))})
+
+		buf := make([]byte, LevelBufferSize(level, MemoryMin)-1)
+		if _, err := NewWriterLevel(io.Discard, level, Opts{LevelBuffer: buf}); err != errLevelBufferSize {
+			t.Errorf("Testfail: level %d: short LevelBuffer returned %v", level, err)
+		}
+	}
+	if LevelBufferSize(1, MemoryDefault) != LevelBufferSize(1, MemoryLarge) {
+		t.Error("Testfail: MemoryDefault differs from MemoryLarge")
+	}
+	if _, err := NewWriterLevel(io.Discard, 1, Opts{MemoryLevel: MemoryExtraLarge + 1}); err != errInvalidMemoryLevel {
+		t.Errorf("Testfail: invalid memory level returned %v", err)
+	}
+	if levelBufSizes != isalLevelBufSizes {
+		t.Errorf("Testfail: level buffer sizes %v, igzip_lib.h has %v", levelBufSizes, isalLevelBufSizes)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+// nativeFreed reports whether the ISA-L Writer w has released its level
+// buffer. It is false for Writers of other backends.
+func nativeFreed(w *Writer) bool {
+	z, ok := w.impl.(*isalWriter)
+	return ok && z.freed
+}
+
+func TestWriterReleasesNativeMemory(t *testing.T) {
+	requireISAL(t)
+	for level := 0; level <= 3; level++ {
+		b := new(bytes.Buffer)
+		w, err := NewWriterLevel(b, level, Opts{Backend: BackendISAL})
+		if err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		// Compress, Close, Reset and again, the way a pooled Writer is used.
+		for i := 0; i < 3; i++ {
+			w.Write([]byte(strGettysBurgAddress))
+			if err := w.Close(); err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			if !nativeFreed(w) {
+				t.Fatalf("Testfail: level %d: Close kept the level buffer", level)
+			}
+			g, _ := gzip.NewReader(b)
+			if got, err := io.ReadAll(g); err != nil || string(got) != strGettysBurgAddress {
+				t.Fatalf("Testfail: level %d, round %d: %v", level, i, err)
+			}
+			b.Reset()
+			if err := w.Reset(b); err != nil || nativeFreed(w) {
+				t.Fatalf("Testfail: level %d: Reset did not set the stream up again: %v", level, err)
+			}
+		}
+		w.Close()
+
+		// A failed Write must not keep the buffer alive either.
+		w, _ = NewWriterLevel(failWriter{}, level, Opts{Backend: BackendISAL})
+		if _, err := w.Write([]byte(strGettysBurgAddress)); err == nil {
+			w.Flush()
+		}
+		if err := w.Close(); err == nil || !nativeFreed(w) {
+			t.Fatalf("Testfail: level %d: Close after a failed write returned %v, freed %v", level, err, nativeFreed(w))
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	dict := []byte(strGettysBurgAddress[:200])
+	compress := func(w *Writer, b *bytes.Buffer) []byte {
+		w.Write([]byte(strGettysBurgAddress))
+		if err := w.Close(); err != nil {
+			t.Fatal("Testfail:", err)
+		}
+		return append([]byte(nil), b.Bytes()...)
+	}
+
+	// The settings are kept, the window size where the backend has one.
+	for _, be := range backends() {
+		o := Opts{Backend: be}
+		if be == BackendISAL {
+			o.WindowBits = 12
+		}
+		for _, f := range []Format{Gzip, Zlib, Deflate} {
+			o.Format = f
+			b := new(bytes.Buffer)
+			fresh, _ := NewWriterDict(b, 2, dict, o)
+			want := compress(fresh, b)
+
+			// Leave a stream half written with a header set, then reuse the
+			// Writer; the result must not differ from a new one.
+			w, _ := NewWriterDict(io.Discard, 2, dict, o)
+			w.Name = "stale"
+			w.Write([]byte(strGettysBurgAddress))
+			for i := 0; i < 2; i++ {
+				b.Reset()
+				if err := w.Reset(b); err != nil {
+					t.Fatal("Testfail:", err)
+				}
+				if got := compress(w, b); !bytes.Equal(got, want) {
+					t.Fatalf("Testfail: %v, format %d, round %d: Reset Writer output differs from a new Writer's", be, f, i)
+				}
+			}
+
+			r, err := NewReaderDict(bytes.NewReader(want), dict, o)
+			if err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			r.Read(make([]byte, 10))
+			r.Close()
+			for i := 0; i < 2; i++ {
+				if err := r.Reset(bytes.NewReader(want)); err != nil {
+					t.Fatal("Testfail:", err)
+				}
+				if got, err := io.ReadAll(r); err != nil || string(got) != strGettysBurgAddress {
+					t.Fatalf("Testfail: %v, format %d, round %d: Reset Reader returned %v", be, f, i, err)
+				}
+			}
+			r.Close()
+		}
+	}
+
+	// A Reset gzip Reader reads the new header and all members again.
+	var two bytes.Buffer
+	for _, name := range []string{"a", "b"} {
+		g := gzip.NewWriter(&two)
+		g.Name = name
+		g.Write([]byte(name))
+		g.Close()
+	}
+	r, _ := NewReader(bytes.NewReader(two.Bytes()))
+	r.Multistream(false)
+	if got, _ := io.ReadAll(r); string(got) != "a" {
+		t.Fatalf("Testfail: Multistream(false) read %q", got)
+	}
+	if err := r.Reset(bytes.NewReader(two.Bytes())); err != nil || r.Name != "a" {
+		t.Fatalf("Testfail: Reset returned %v with name %q", err, r.Name)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "ab" || r.Name != "b" {
+		t.Fatalf("Testfail: read %q, %v after Reset, last name %q", got, err, r.Name)
+	}
+	if err := r.Reset(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("Testfail: Reset on empty input returned %v", err)
+	}
+	r.Close()
+}
+
+// raceEnabled is set when the tests run with the race detector.
+var raceEnabled bool
+
+func TestCompressDecompress(t *testing.T) {
+	text := []byte(strings.Repeat(strGettysBurgAddress, 20))
+	random := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, f := range []Format{Gzip, Zlib, Deflate} {
+		for level := 0; level <= 3; level++ {
+			for _, src := range [][]byte{nil, []byte("a"), text, random} {
+				c, err := Compress([]byte("prefix"), src, level, f)
+				if err != nil {
+					t.Fatalf("Testfail: format %d, level %d: %v", f, level, err)
+				}
+				if string(c[:6]) != "prefix" || len(c)-6 > CompressBound(len(src)) {
+					t.Fatalf("Testfail: format %d, level %d: %d bytes for %d, bound %d", f, level, len(c)-6, len(src), CompressBound(len(src)))
+				}
+				c = c[6:]
+
+				var r io.Reader
+				switch f {
+				case Gzip:
+					r, err = gzip.NewReader(bytes.NewReader(c))
+				case Zlib:
+					r, err = zlib.NewReader(bytes.NewReader(c))
+				default:
+					r = flate.NewReader(bytes.NewReader(c))
+				}
+				if err != nil {
+					t.Fatal("Testfail:", err)
+				}
+				if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, src) {
+					t.Fatalf("Testfail: format %d, level %d: compress/... could not read the output: %v", f, level, err)
+				}
+
+				// Too little room at first for the incompressible data.
+				got, err := Decompress(make([]byte, 0, 10), c)
+				if err != nil || !bytes.Equal(got, src) {
+					t.Fatalf("Testfail: format %d, level %d: Decompress returned %d bytes: %v", f, level, len(got), err)
+				}
+			}
+		}
+	}
+
+	// Output of compress/gzip, and buffers reused without allocating.
+	b := new(bytes.Buffer)
+	g := gzip.NewWriter(b)
+	g.Write(text)
+	g.Close()
+	dst := make([]byte, 0, len(text))
+	if got, err := Decompress(dst, b.Bytes()); err != nil || !bytes.Equal(got, text) {
+		t.Fatalf("Testfail: Decompress of compress/gzip output: %v", err)
+	}
+	cbuf := make([]byte, 0, CompressBound(len(text)))
+	allocs := testing.AllocsPerRun(10, func() {
+		c, _ := Compress(cbuf[:0], text, 1, Gzip)
+		dst, _ = Decompress(dst[:0], c)
+	})
+	// Only ISA-L manages without allocating.
+	if (allocs != 0 && !raceEnabled && Backend() == BackendISAL) || !bytes.Equal(dst, text) {
+		t.Errorf("Testfail: %v allocations per round trip into reused buffers", allocs)
+	}
+
+	c, _ := Compress(nil, text, 1, Gzip)
+	if _, err := Decompress(nil, c[:len(c)-10]); err != io.ErrUnexpectedEOF {
+		t.Errorf("Testfail: truncated input returned %v", err)
+	}
+	c[len(c)-5] ^= 1
+	if _, err := Decompress(nil, c); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Testfail: corrupt CRC returned %v", err)
+	}
+	if _, err := Compress(nil, text, 4, Gzip); err != ErrInvalidLevel {
+		t.Errorf("Testfail: level 4 returned %v", err)
+	}
+	if _, err := Compress(nil, text, 1, GzipNoHeader); err != errInvalidFormat {
+		t.Errorf("Testfail: GzipNoHeader returned %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	requireISAL(t)
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() { done <- LoadDefault() }()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-done; err != nil {
+			t.Fatal("Testfail:", err)
+		}
+	}
+	if err := Load("/nonexistent/libisal.so"); err != nil || !Ready() {
+		t.Fatalf("Testfail: a second Load returned %v", err)
+	}
+
+	if info, _ := Capabilities(); info.Static {
+		t.Skip("ISA-L is linked in, there is nothing to load")
+	}
+	var le *LoadError
+	_, _, err := dload("/nonexistent/libisal.so")
+	if !errors.As(err, &le) || le.Symbol != "" || le.Msg == "" {
+		t.Fatalf("Testfail: loading a missing file returned %v", err)
+	}
+	le = nil
+	_, _, err = dload("libz.so.1")
+	if errors.As(err, &le) && le.Symbol == "" {
+		t.Logf("libz.so.1 not available: %v", err)
+	} else if le == nil || le.Symbol != "isal_inflate_init" {
+		t.Fatalf("Testfail: loading a library without ISA-L returned %v", err)
+	}
+
+	// Failed loads leave the loaded library alone.
+	c, err := Compress(nil, []byte(strGettysBurgAddress), 1, Gzip)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if got, err := Decompress(nil, c); err != nil || string(got) != strGettysBurgAddress {
+		t.Fatalf("Testfail: round trip after failed loads: %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	requireISAL(t)
+	info, err := Capabilities()
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	t.Logf("%+v", info)
+	if _, err := os.Stat(info.Path); err != nil && !info.Static {
+		t.Errorf("Testfail: library path %q: %v", info.Path, err)
+	}
+	if soVersion("libisal.so.2.0.30") != "2.0.30" || soVersion("libisal.so") != "" {
+		t.Error("Testfail: soVersion")
+	}
+
+	// Pretend to run on an old library that has none of the optional
+	// functions.
+	saved := features
+	features = 0
+	defer func() { features = saved }()
+
+	dict := []byte(strGettysBurgAddress[:100])
+	unsupported := map[string]error{}
+	_, unsupported["NewWriterDict"] = NewWriterDict(io.Discard, 1, dict)
+	_, unsupported["NewReaderDict"] = NewReaderDict(bytes.NewReader(nil), dict, Opts{Format: Deflate})
+	_, unsupported["NewDictionary"] = NewDictionary(dict, 1)
+	_, unsupported["HuffmanStatic"] = NewWriterLevel(io.Discard, 1, Opts{Huffman: HuffmanStatic})
+	_, unsupported["NewHuffmanTables"] = NewHuffmanTables(new(Histogram))
+	unsupported["Histogram.Add"] = new(Histogram).Add(dict)
+	_, unsupported["NewZlibWriter"] = NewZlibWriter(io.Discard)
+	for name, err := range unsupported {
+		if err != ErrUnsupported {
+			t.Errorf("Testfail: %s returned %v", name, err)
+		}
+	}
+
+	// Without isal_deflate_process_dict a Dictionary is left for each
+	// Writer to hash.
+	features = saved &^ featureDictProcess
+	d, err := NewDictionary(dict, 1)
+	if err != nil || d.prepared != nil {
+		t.Fatalf("Testfail: NewDictionary without process_dict returned %v", err)
+	}
+	b := new(bytes.Buffer)
+	w, err := NewWriterDictionary(b, d, Opts{Format: Zlib})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Write(dict)
+	w.Close()
+	if zr, err := zlib.NewReaderDict(b, dict); err != nil {
+		t.Fatal("Testfail:", err)
+	} else if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, dict) {
+		t.Fatalf("Testfail: unprepared Dictionary round trip: %v", err)
+	}
+	features = 0
+
+	// The rest works as before.
+	b.Reset()
+	w, err = NewWriter(b)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	w.Write([]byte(strGettysBurgAddress))
+	w.Close()
+	if got, err := Decompress(nil, b.Bytes()); err != nil || string(got) != strGettysBurgAddress {
+		t.Fatalf("Testfail: round trip without optional functions: %v", err)
+	}
+	b.Reset()
+	zw := zlib.NewWriter(b)
+	zw.Write([]byte(strGettysBurgAddress))
+	zw.Close()
+	z, err := NewZlibReader(b)
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	if got, err := io.ReadAll(z); err != nil || string(got) != strGettysBurgAddress {
+		t.Fatalf("Testfail: zlib without optional functions: %v", err)
+	}
+	z.Close()
+}
+
 func TestDeflateInflate(t *testing.T) {
+	// A single Read returning the whole file is ISA-L's behavior.
+	requireISAL(t)
 
 	var b bytes.Buffer
 	w, _ := gzip.NewWriterLevel(&b, 5)
@@ -143,12 +1366,12 @@ func TestDeflateInflate(t *testing.T) {
 	w.Close()
 
 	var b1 bytes.Buffer
-	z, _ := NewWriterLevel(&b1, 2)
+	z, _ := NewWriterLevel(&b1, 2, Opts{Backend: BackendISAL})
 	z.Write(textTwain)
 	z.Close()
 
 	buf1 := make([]byte, 1024*1024)
-	r, _ := NewReader(bytes.NewReader(b.Bytes()))
+	r, _ := NewReader(bytes.NewReader(b.Bytes()), Opts{Backend: BackendISAL})
 	n5, _ := r.Read(buf1)
 
 	fmt.Printf("%d n5\n", n5)
@@ -162,7 +1385,7 @@ func TestDeflateInflate(t *testing.T) {
 	}
 
 	buf2 := make([]byte, 8*1024*1024)
-	q, _ := NewReader(bytes.NewReader(b1.Bytes()))
+	q, _ := NewReader(bytes.NewReader(b1.Bytes()), Opts{Backend: BackendISAL})
 	n2, _ := q.Read(buf2)
 
 	q.Close()
@@ -547,6 +1770,112 @@ func BenchmarkDecodeISAL(b *testing.B) {
 	})
 }
 
+// BenchmarkDecodeISALReset is BenchmarkDecodeISAL with one Reader reused
+// through Reset and read to the end, the way a server decodes many streams.
+func BenchmarkDecodeISALReset(b *testing.B) {
+	runtime.GC()
+	doBench(b, func(b *testing.B, buf0 []byte, level, n int) {
+		b.ReportAllocs()
+		b.StopTimer()
+
+		compressed := new(bytes.Buffer)
+		w, err := NewWriterLevel(compressed, level)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w.Write(buf0)
+
+		b.SetBytes(int64(len(buf0)))
+		w.Close()
+
+		buf1 := compressed.Bytes()
+		buf0, compressed, w = nil, nil, nil
+		buf4 := make([]byte, 2*1024*1024)
+		in := bytes.NewReader(buf1)
+		br, err := NewReader(in)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer br.Close()
+		runtime.GC()
+		b.StartTimer()
+		for i := 0; i < b.N; i++ {
+			in.Reset(buf1)
+			br.Reset(in)
+			readAll(br, buf4)
+		}
+	})
+}
+
+// readAll reads r to the end into buf, without allocating like io.ReadAll.
+func readAll(r io.Reader, buf []byte) (n int, err error) {
+	for {
+		m, err := r.Read(buf)
+		n += m
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// TestReaderAllocs checks that a reused Reader decodes without allocating,
+// for small reads as well as large ones. Only a gzip Name or Comment, which
+// become new strings in Header, would cost an allocation.
+func TestReaderAllocs(t *testing.T) {
+	requireISAL(t)
+	b := new(bytes.Buffer)
+	w, _ := NewWriter(b)
+	w.Write([]byte(strings.Repeat(strGettysBurgAddress, 100)))
+	w.Close()
+
+	in := bytes.NewReader(b.Bytes())
+	z, err := NewReader(in, Opts{Backend: BackendISAL})
+	if err != nil {
+		t.Fatal("Testfail:", err)
+	}
+	defer z.Close()
+	for _, size := range []int{16, 4096, 1 << 20} {
+		buf := make([]byte, size)
+		allocs := testing.AllocsPerRun(20, func() {
+			in.Reset(b.Bytes())
+			if err := z.Reset(in); err != nil {
+				t.Fatal("Testfail:", err)
+			}
+			if n, err := readAll(z, buf); err != nil || n != 100*len(strGettysBurgAddress) {
+				t.Fatalf("Testfail: read %d bytes: %v", n, err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Testfail: %d byte reads: %v allocations per stream", size, allocs)
+		}
+	}
+}
+
+func BenchmarkDecodeISALSmallReads(b *testing.B) {
+	compressed := new(bytes.Buffer)
+	w, _ := NewWriter(compressed)
+	w.Write([]byte(strings.Repeat(strGettysBurgAddress, 1000)))
+	w.Close()
+
+	in := bytes.NewReader(compressed.Bytes())
+	z, err := NewReader(in)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer z.Close()
+	buf := make([]byte, 512)
+	b.SetBytes(int64(1000 * len(strGettysBurgAddress)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in.Reset(compressed.Bytes())
+		z.Reset(in)
+		readAll(z, buf)
+	}
+}
+
 func BenchmarkDecodeNative(b *testing.B) {
 	doBench(b, func(b *testing.B, buf0 []byte, level, n int) {
 		b.ReportAllocs()
@@ -594,7 +1923,7 @@ func BenchmarkEncodeISAL(b *testing.B) {
 		runtime.GC()
 		b.StartTimer()
 		for i := 0; i < b.N; i++ {
-			//        w.Reset(io.Discard)
+			w.Reset(io.Discard)
 			w.Write(buf1)
 			w.Close()
 		}
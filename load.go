@@ -0,0 +1,81 @@
+package isal
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	loadOnce sync.Once
+	loadErr  error
+	libInfo  LibraryInfo
+)
+
+// LibraryInfo describes the ISA-L library that was loaded. Older releases of
+// ISA-L lack some of the functions this package can use; the features that
+// depend on them report ErrUnsupported.
+type LibraryInfo struct {
+	// Static is set if ISA-L is linked into the binary (build tag
+	// isal_static). Path and Version are then empty.
+	Static bool
+	// Path is the file the library was loaded from.
+	Path string
+	// Version is the version suffix of that file once symbolic links are
+	// followed, e.g. "2.0.30" for libisal.so.2.0.30 as installed by ISA-L
+	// 2.30. It is empty if the file name carries no version.
+	Version string
+
+	Dictionaries         bool // NewWriterDict and NewReaderDict
+	PreparedDictionaries bool // NewDictionary
+	Huffman              bool // Opts.Huffman, HuffmanTables and Histogram
+	ZlibHeaders          bool // Writers in the Zlib format
+	CRC                  bool // ISA-L's CRC functions
+	ErasureCoding        bool // ISA-L's erasure code functions
+}
+
+// Capabilities loads the library with LoadDefault, if that has not happened
+// yet, and reports what it provides.
+func Capabilities() (LibraryInfo, error) {
+	if err := LoadDefault(); err != nil {
+		return LibraryInfo{}, err
+	}
+	return libInfo, nil
+}
+
+// Load loads the ISA-L shared library at path and resolves the functions this
+// package calls. Only the first call to Load or LoadDefault has an effect;
+// later calls return its result. The constructors call LoadDefault
+// themselves, so Load is only needed to use a library at another path, or to
+// find out early why the library is unavailable: the error is a *LoadError.
+//
+// Load is safe for concurrent use and never writes to stdout or stderr. In
+// binaries built with the isal_static tag, which link ISA-L in, there is
+// nothing to load: path is ignored and Load always succeeds. In binaries
+// built without cgo Load always fails, and the Go backend is used.
+func Load(path string) error {
+	loadOnce.Do(func() { load(path) })
+	return loadErr
+}
+
+// LoadDefault is like Load with the path given by the ISAL_LIB_PATH
+// environment variable or, if that is not set, libisal.so looked up in the
+// dynamic linker's search path.
+func LoadDefault() error {
+	loadOnce.Do(func() {
+		path := os.Getenv("ISAL_LIB_PATH")
+		if path == "" {
+			path = defaultLibPath
+		}
+		load(path)
+	})
+	return loadErr
+}
+
+// soVersion returns the version suffix of a shared library file name.
+func soVersion(name string) string {
+	if i := strings.LastIndex(name, ".so."); i >= 0 {
+		return name[i+len(".so."):]
+	}
+	return ""
+}
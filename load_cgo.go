@@ -0,0 +1,71 @@
+package isal
+
+//#include <stdlib.h>
+//#include "igzip_lib.h"
+//#include <isal_native.h>
+import "C"
+
+import (
+	"path/filepath"
+	"unsafe"
+)
+
+// defaultLibPath is the library LoadDefault loads without ISAL_LIB_PATH.
+const defaultLibPath = C.ISAL_LIB
+
+// features holds the IG_FEATURE_* bits of the loaded library.
+var features C.int
+
+// require returns ErrUnsupported unless the loaded library has the feature.
+func require(feature C.int) error {
+	if features&feature != feature {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func load(path string) {
+	var file string
+	file, features, loadErr = dload(path)
+	if loadErr != nil {
+		return
+	}
+	LIB_LOADED = 1
+
+	libInfo = LibraryInfo{
+		Static:               C.IG_STATIC == 1,
+		Path:                 file,
+		Dictionaries:         require(C.IG_FEATURE_DICT) == nil,
+		PreparedDictionaries: require(C.IG_FEATURE_DICT_PROCESS) == nil,
+		Huffman:              require(C.IG_FEATURE_HUFFMAN) == nil,
+		ZlibHeaders:          require(C.IG_FEATURE_ZLIB_HEADER) == nil,
+		CRC:                  require(C.IG_FEATURE_CRC) == nil,
+		ErasureCoding:        require(C.IG_FEATURE_EC) == nil,
+	}
+	if file == "" {
+		return
+	}
+	if real, err := filepath.EvalSymlinks(file); err == nil {
+		libInfo.Version = soVersion(filepath.Base(real))
+	}
+}
+
+// dload opens the library at path and resolves its functions, which only
+// happens if all the required ones are found. It returns the file that was
+// loaded and the features it provides.
+func dload(path string) (string, C.int, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	var missing *C.char
+	var msg, file [4096]C.char
+	var have C.int
+	if C.isal_dload_functions(cpath, &missing, &msg[0], C.int(len(msg)), &have, &file[0], C.int(len(file))) != 0 {
+		e := &LoadError{Path: path, Msg: C.GoString(&msg[0])}
+		if missing != nil {
+			e.Symbol = C.GoString(missing)
+		}
+		return "", 0, e
+	}
+	return C.GoString(&file[0]), have, nil
+}
@@ -0,0 +1,66 @@
+//go:build !cgo
+
+package isal
+
+import "io"
+
+// Without cgo there is no ISA-L to load, and Backend always reports
+// BackendGo. The functions below stand in for the ones that call into ISA-L
+// so that the package builds; the ISA-L backend is never selected, and the
+// Huffman functions, which only ISA-L provides, fail.
+
+const defaultLibPath = "libisal.so"
+
+func load(path string) {
+	loadErr = &LoadError{Path: path, Msg: "built without cgo"}
+}
+
+type isaldict struct{}
+type isalhistogram struct{}
+type isalhufftables struct{}
+
+func (d *Dictionary) prepare() error {
+	return ErrUnsupported
+}
+
+func newISALWriter(w io.Writer, level int, opts Opts) (writerImpl, error) {
+	return nil, LoadDefault()
+}
+
+func newISALReader(hdr *Header, r io.Reader, dict []byte, opts Opts) (readerImpl, error) {
+	return nil, LoadDefault()
+}
+
+func isalCompress(dst, src []byte, level int, format Format) ([]byte, error) {
+	return nil, LoadDefault()
+}
+
+func isalDecompress(dst, src []byte) ([]byte, error) {
+	return nil, LoadDefault()
+}
+
+// Add needs ISA-L's match finder and returns the error of LoadDefault.
+func (h *Histogram) Add(sample []byte) error {
+	return LoadDefault()
+}
+
+// NewHuffmanTables needs ISA-L and returns the error of LoadDefault.
+func NewHuffmanTables(h *Histogram) (*HuffmanTables, error) {
+	return nil, LoadDefault()
+}
+
+// NewHuffmanTablesSubset needs ISA-L and returns the error of LoadDefault.
+func NewHuffmanTablesSubset(h *Histogram) (*HuffmanTables, error) {
+	return nil, LoadDefault()
+}
+
+// MarshalBinary returns ErrUnsupported: there are no tables to encode.
+func (t *HuffmanTables) MarshalBinary() ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// UnmarshalBinary returns ErrHuffmanTables: no profile fits a build without
+// ISA-L.
+func (t *HuffmanTables) UnmarshalBinary(data []byte) error {
+	return ErrHuffmanTables
+}
@@ -0,0 +1,8 @@
+//go:build race
+
+package isal
+
+func init() {
+	// The race detector makes sync.Pool drop items at random.
+	raceEnabled = true
+}
@@ -0,0 +1,528 @@
+package isal
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// cPool is a pool of buffers for use in reader.compressionBuffer. Buffers are
+// taken from the pool in NewReaderDict, returned in reader.Close(). Returns a
+// pointer to a slice to avoid the extra allocation of returning the slice as a
+// value.
+var cPool = sync.Pool{
+	New: func() interface{} {
+		buff := make([]byte, D_BUF_SIZE)
+		return &buff
+	},
+}
+
+func resize(in []byte, newSize int) []byte {
+	if in == nil {
+		return make([]byte, newSize)
+	}
+	if newSize <= cap(in) {
+		return in[:newSize]
+	}
+	toAdd := newSize - len(in)
+	return append(in, make([]byte, toAdd)...)
+}
+
+// isalReader is the Reader backend that inflates with ISA-L, through an
+// inflater.
+type isalReader struct {
+	f                 inflater
+	hdr               *Header
+	underlyingReader  io.Reader
+	inEOF             bool // true if in reaches io.EOF
+	hdrExtra          []byte
+	hdrName           []byte
+	hdrComment        []byte
+	compressionBuffer []byte
+	compressionBufP   *[]byte // cPool entry of compressionBuffer
+	inPos, inEnd      int     // compressionBuffer[inPos:inEnd] is input not yet consumed
+	format            Format
+	multistream       bool
+	dict              []byte // preset dictionary, nil if none
+	windowBits        int
+	inOffset          int64 // compressed bytes consumed, for CodecError
+}
+
+func newInflateReader(f inflater, hdr *Header, in io.Reader, dict []byte, opts Opts) (readerImpl, error) {
+	if len(dict) > 0 {
+		if err := f.require(featureDict); err != nil {
+			return nil, err
+		}
+	}
+	compressionBufferP := cPool.Get().(*[]byte)
+	z := &isalReader{
+		f:                 f,
+		hdr:               hdr,
+		underlyingReader:  in,
+		compressionBuffer: *compressionBufferP,
+		compressionBufP:   compressionBufferP,
+		format:            opts.Format,
+		multistream:       true,
+		dict:              dict,
+		windowBits:        opts.WindowBits,
+	}
+	if ec := f.init(); ec != 0 {
+		return nil, inflateError(ec, 0)
+	}
+	if ec := z.setDict(); ec != 0 {
+		return nil, inflateError(ec, 0)
+	}
+
+	if z.format == Gzip {
+		if err := z.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	return z, nil
+}
+
+// setDict hands the preset dictionary, if any, to a freshly initialized
+// inflate state. A zlib stream announces in its header whether it needs the
+// dictionary, so for Zlib this is left to read.
+func (z *isalReader) setDict() int {
+	if len(z.dict) == 0 || z.format == Zlib {
+		return 0
+	}
+	return z.f.setDict(z.dict)
+}
+
+// readHeader parses the gzip header at the start of the input into z.hdr,
+// leaving whatever compressed data follows it in the input window for read.
+// It returns io.EOF if the input ends before the first byte of a header.
+func (z *isalReader) readHeader() error {
+	total := 0
+
+	if z.hdrName == nil {
+		z.hdrExtra = make([]byte, 256)
+		z.hdrName = make([]byte, 256)
+		z.hdrComment = make([]byte, 256)
+	}
+	z.hdrName[0], z.hdrComment[0] = 0, 0
+	z.f.initGzipHeader()
+
+	for {
+		if z.inPos == z.inEnd {
+			if z.inEOF {
+				if total == 0 {
+					return io.EOF
+				}
+				return io.ErrUnexpectedEOF
+			}
+			if err := z.fill(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		used, ret := z.f.readGzipHeader(z.compressionBuffer[z.inPos:z.inEnd], z.hdrExtra, z.hdrName, z.hdrComment)
+		z.inPos += used
+		z.inOffset += int64(used)
+		total += used
+
+		switch ret {
+		case codeDecompOK:
+			z.setHeader()
+			return nil
+		case codeEndInput:
+			// The header continues in the next read.
+		case codeExtraOverflow:
+			z.hdrExtra = growHeaderBuf(z.hdrExtra)
+		case codeNameOverflow:
+			z.hdrName = growHeaderBuf(z.hdrName)
+		case codeCommentOverflow:
+			z.hdrComment = growHeaderBuf(z.hdrComment)
+		default:
+			return inflateError(ret, z.inOffset)
+		}
+	}
+}
+
+// fill reads the next chunk of compressed input into the window once the
+// previous one has been consumed. It may return with the window still empty;
+// z.inEOF is set when the underlying reader is exhausted.
+func (z *isalReader) fill() error {
+	n, err := z.underlyingReader.Read(z.compressionBuffer)
+	z.inPos, z.inEnd = 0, n
+	if err == io.EOF {
+		z.inEOF = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// setHeader copies the header the inflater parsed to z.hdr.
+func (z *isalReader) setHeader() {
+	mtime, os, extraLen := z.f.gzipHeader()
+
+	*z.hdr = Header{
+		Name:    decodeLatin1(z.hdrName),
+		Comment: decodeLatin1(z.hdrComment),
+		OS:      os,
+	}
+	if mtime > 0 {
+		z.hdr.ModTime = time.Unix(int64(mtime), 0)
+	}
+	// Like compress/gzip, an empty extra field is an empty but non-nil
+	// Extra, which the Writer writes back.
+	if extraLen >= 0 {
+		z.hdr.Extra = append([]byte{}, z.hdrExtra[:extraLen]...)
+	}
+}
+
+// growHeaderBuf doubles a header field buffer after ISA-L reported an
+// overflow. ISA-L resumes writing where it stopped, so the contents are kept.
+func growHeaderBuf(b []byte) []byte {
+	nb := make([]byte, 2*len(b))
+	copy(nb, b)
+	return nb
+}
+
+// read inflates straight into p, see Reader.Read.
+func (z *isalReader) read(p []byte) (n int, err error) {
+	for n < len(p) {
+		used, produced, done, ret := z.f.inflate(z.compressionBuffer[z.inPos:z.inEnd], p[n:], z.format, z.windowBits)
+		z.inPos += used
+		z.inOffset += int64(used)
+		n += produced
+		if ret == codeNeedDict && len(z.dict) > 0 {
+			ret = z.f.setDict(z.dict)
+		}
+		if err := inflateError(ret, z.inOffset); err != nil {
+			return n, err
+		}
+
+		if done {
+			// The member is finished. A gzip stream may be followed by
+			// further members, each with its own header.
+			if !z.multistream || z.format != Gzip {
+				return n, io.EOF
+			}
+			z.f.reset()
+			if ec := z.setDict(); ec != 0 {
+				return n, inflateError(ec, z.inOffset)
+			}
+			if err := z.readHeader(); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if z.inPos < z.inEnd {
+			continue
+		}
+		if n > 0 {
+			// Hand out what there is rather than block on the input.
+			return n, nil
+		}
+		if z.inEOF {
+			// The input ended before the trailer of the stream.
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err := z.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (z *isalReader) setMultistream(ok bool) {
+	z.multistream = ok
+}
+
+// close hands the input window back to the pool.
+func (z *isalReader) close() {
+	cb := z.compressionBufP
+	// Ensure that we won't resuse buffer
+	z.compressionBuffer, z.compressionBufP = nil, nil
+	z.inPos, z.inEnd = 0, 0
+	if cb != nil {
+		cPool.Put(cb)
+	}
+}
+
+func (z *isalReader) reset(r io.Reader) error {
+	if z.compressionBuffer == nil {
+		z.compressionBufP = cPool.Get().(*[]byte)
+		z.compressionBuffer = *z.compressionBufP
+	}
+	z.underlyingReader = r
+	z.inEOF = false
+	z.inPos, z.inEnd = 0, 0
+	z.multistream = true
+	z.inOffset = 0
+
+	z.f.reset()
+	if ec := z.setDict(); ec != 0 {
+		return inflateError(ec, 0)
+	}
+	if z.format == Gzip {
+		return z.readHeader()
+	}
+	return nil
+}
+
+// isalWriter is the Writer backend that deflates with ISA-L, through a
+// deflater.
+type isalWriter struct {
+	d            deflater
+	out          io.Writer
+	outBuf       []byte
+	level        int
+	format       Format
+	dict         *Dictionary // preset dictionary, nil if none
+	huffMode     HuffmanMode
+	huff         *HuffmanTables
+	windowBits   int
+	levelBuf     []byte // Go-owned level buffer, nil if allocated in C
+	levelBufSize int
+	freed        bool // the native level buffer has been released
+	wroteHeader  bool
+	inOffset     int64 // uncompressed bytes consumed, for CodecError
+}
+
+func newDeflateWriter(d deflater, w io.Writer, level int, opts Opts) (writerImpl, error) {
+	if opts.Huffman != HuffmanDefault || opts.HuffmanTables != nil {
+		if err := d.require(featureHuffman); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Format == Zlib {
+		if err := d.require(featureZlibHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	z := &isalWriter{
+		d:            d,
+		out:          w,
+		outBuf:       make([]byte, C_BUF_SIZE),
+		level:        level,
+		format:       opts.Format,
+		windowBits:   opts.WindowBits,
+		huffMode:     opts.Huffman,
+		huff:         opts.HuffmanTables,
+		levelBufSize: LevelBufferSize(level, opts.MemoryLevel),
+	}
+	if opts.LevelBuffer != nil {
+		z.levelBuf = opts.LevelBuffer
+		z.levelBufSize = len(z.levelBuf)
+	}
+
+	if err := z.initStream(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// setDict requires the dictionary functions unless d was prepared by
+// NewDictionary, whose own check covers it.
+func (z *isalWriter) setDict(d *Dictionary) error {
+	if d.prepared == nil && len(d.raw) > 0 {
+		if err := z.d.require(featureDict); err != nil {
+			return err
+		}
+	}
+	z.dict = d
+	return z.applyDict()
+}
+
+// applyDict hands z's dictionary, if any, to a freshly reset deflate state.
+func (z *isalWriter) applyDict() error {
+	if z.dict == nil || len(z.dict.raw) == 0 {
+		return nil
+	}
+	if ec := z.d.setDict(z.dict); ec != 0 {
+		return deflateError(ec, 0)
+	}
+	return nil
+}
+
+// initStream sets up the deflate state from z's configuration, allocating the
+// level buffer in C unless z.levelBuf is used.
+func (z *isalWriter) initStream() error {
+	if ec := z.d.init(z.level, z.windowBits, z.levelBufSize, z.levelBuf); ec != 0 {
+		return deflateError(ec, 0)
+	}
+	z.freed = false
+
+	// init selects the default code already, and libraries without custom
+	// Huffman support lack set_hufftables.
+	if z.huffMode == HuffmanDefault && z.huff == nil {
+		return nil
+	}
+	if ec := z.d.setHufftables(z.huffMode, z.huff); ec != 0 {
+		z.free()
+		return deflateError(ec, 0)
+	}
+	return nil
+}
+
+// free releases the native level buffer. It is safe to call more than once.
+func (z *isalWriter) free() {
+	if !z.freed {
+		z.d.end()
+		z.freed = true
+	}
+}
+
+func (z *isalWriter) write(hdr *Header, in []byte) error {
+	return z.deflate(hdr, in, noFlush, false)
+}
+
+func (z *isalWriter) flush(hdr *Header, full bool) error {
+	if full {
+		return z.deflate(hdr, nil, fullFlush, false)
+	}
+	return z.deflate(hdr, nil, syncFlush, false)
+}
+
+func (z *isalWriter) close(hdr *Header) error {
+	return z.deflate(hdr, nil, noFlush, true)
+}
+
+// deflate runs in through the stream using the given flush mode, writing the
+// output to the underlying writer as outBuf fills up. It returns once all of
+// in has been consumed and ISA-L has nothing more to emit; with endOfStream
+// set it keeps going until the trailer has been written. hdr is written ahead
+// of the first block.
+func (z *isalWriter) deflate(hdr *Header, in []byte, flush int, endOfStream bool) error {
+	if !z.wroteHeader {
+		if err := z.writeHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	for {
+		used, nOut, done, ret := z.d.deflate(in, z.outBuf, flush, endOfStream, z.format)
+		z.inOffset += int64(used)
+		if ret != 0 {
+			return deflateError(ret, z.inOffset)
+		}
+
+		in = in[used:]
+
+		if nOut > 0 {
+			if err := z.output(z.outBuf[:nOut]); err != nil {
+				return err
+			}
+		}
+
+		if endOfStream {
+			if done {
+				return nil
+			}
+			continue
+		}
+		if len(in) == 0 && nOut < len(z.outBuf) {
+			return nil
+		}
+	}
+}
+
+// writeHeader writes the gzip or zlib header that goes ahead of the first
+// deflate block. The other formats have no header.
+func (z *isalWriter) writeHeader(hdr *Header) error {
+	z.wroteHeader = true
+	out := z.outBuf
+
+	for {
+		var n, ret int
+
+		switch z.format {
+		case Gzip:
+			var err error
+			n, ret, err = z.writeGzipHeader(out, hdr)
+			if err != nil {
+				return err
+			}
+		case Zlib:
+			// CINFO is the base two logarithm of the window size minus 8.
+			// ISA-L levels 0-3 line up with the FLEVEL hint, fastest through
+			// maximum.
+			info := 7
+			if z.windowBits != 0 {
+				info = z.windowBits - 8
+			}
+			hasDict, dictID := false, uint32(0)
+			if z.dict != nil && len(z.dict.raw) > 0 {
+				hasDict, dictID = true, z.dict.id
+			}
+			n, ret = z.d.writeZlibHeader(out, info, z.level, hasDict, dictID)
+		default:
+			return nil
+		}
+
+		if ret == 0 {
+			return z.output(out[:n])
+		}
+		// A long name, comment or extra field did not fit; ISA-L reports the
+		// size it needs.
+		out = make([]byte, ret)
+	}
+}
+
+// writeGzipHeader marshals hdr into a gzip header.
+func (z *isalWriter) writeGzipHeader(out []byte, hdr *Header) (n, ret int, err error) {
+	var (
+		mtime         uint32
+		name, comment []byte
+	)
+
+	if hdr.ModTime.After(time.Unix(0, 0)) {
+		// Section 2.3.1, the zero value for MTIME means that the
+		// modified time is not set.
+		mtime = uint32(hdr.ModTime.Unix())
+	}
+	if len(hdr.Extra) > 0xffff {
+		return 0, 0, errHeaderExtra
+	}
+	if hdr.Name != "" {
+		if name, err = latin1String(hdr.Name); err != nil {
+			return 0, 0, err
+		}
+	}
+	if hdr.Comment != "" {
+		if comment, err = latin1String(hdr.Comment); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	n, ret = z.d.writeGzipHeader(out, mtime, hdr.OS, hdr.Extra, name, comment)
+	return n, ret, nil
+}
+
+// output writes the data to the underlying writer.
+func (z *isalWriter) output(data []byte) error {
+	n, err := z.out.Write(data)
+	if err != nil {
+		return err
+	}
+	if n < len(data) { // shouldn't happen in practice
+		return fmt.Errorf("zlib: n=%d, outLen=%d", n, len(data))
+	}
+	return nil
+}
+
+// reset prepares z for a new stream written to w. The level, format and
+// native buffers are kept, or allocated again if Close released them.
+func (z *isalWriter) reset(w io.Writer) error {
+	if z.freed {
+		if err := z.initStream(); err != nil {
+			return err
+		}
+	} else {
+		z.d.reset()
+	}
+	z.out = w
+	z.wroteHeader = false
+	z.inOffset = 0
+	return nil
+}